@@ -0,0 +1,55 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"server/config"
+	"server/middleware"
+	"strings"
+	"time"
+)
+
+// AdminLoginHandler trades the admin token for a cookie so the admin pages work in a browser.
+func AdminLoginHandler(w http.ResponseWriter, r *http.Request) {
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/admin/moderation"
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ServeTemplate(w, r, "admin_login.html", struct {
+			Next  string
+			Error string
+		}{Next: next})
+
+	case http.MethodPost:
+		token := r.PostFormValue("token")
+		if config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			ServeTemplate(w, r, "admin_login.html", struct {
+				Next  string
+				Error string
+			}{Next: next, Error: "Invalid token"})
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     middleware.AdminCookie,
+			Value:    middleware.AdminSession(time.Now()),
+			Path:     "/",
+			MaxAge:   int(middleware.AdminSessionTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(config.BaseURL, "https://"),
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func AdminModerationPageHandler(w http.ResponseWriter, r *http.Request) {
+	ServeTemplate(w, r, "admin_moderation.html", nil)
+}
@@ -7,17 +7,16 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"server/config"
+	"server/logs"
 	"server/types"
 	"sort"
 	"strings"
 	"time"
 
-	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
 	"github.com/yuin/goldmark"
-	highlighting "github.com/yuin/goldmark-highlighting/v2"
-	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
 )
 
 func BlogHandler(w http.ResponseWriter, r *http.Request) {
@@ -30,11 +29,16 @@ func BlogHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		data := types.BlogData{Posts: posts}
+		data := types.BlogData{Posts: publishedPosts(posts, time.Now())}
 		ServeTemplate(w, r, "blog.html", data)
 		return
 	}
 
+	if strings.HasPrefix(path, blogAssetURL) {
+		serveBlogAsset(w, r)
+		return
+	}
+
 	slug := strings.TrimPrefix(path, "/blog/")
 	slug = strings.TrimSuffix(slug, "/")
 
@@ -45,7 +49,33 @@ func BlogHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		ServeTemplate(w, r, "blog-post.html", post)
+		if !isPublished(post, time.Now()) {
+			if !validPreviewToken(slug, r.URL.Query().Get("preview"), time.Now()) {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Cache-Control", "private, no-store")
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		}
+
+		mentions, err := loadApprovedWebmentions(slug)
+		if err != nil {
+			logs.WARN("Failed to load webmentions", map[string]any{"slug": slug, "error": err.Error()})
+		}
+
+		comments, err := loadComments(slug)
+		if err != nil {
+			logs.WARN("Failed to load comments", map[string]any{"slug": slug, "error": err.Error()})
+		}
+
+		w.Header().Set("Link", "<"+config.BaseURL+"/webmention>; rel=\"webmention\"")
+		ServeTemplate(w, r, "blog-post.html", types.BlogPostPage{
+			BlogPost:    post,
+			Meta:        blogPostMeta(post),
+			Webmentions: mentions,
+			Comments:    comments,
+			CommentSent: r.URL.Query().Get("comment") == "sent",
+		})
 		return
 	}
 
@@ -55,25 +85,7 @@ func BlogHandler(w http.ResponseWriter, r *http.Request) {
 func loadBlogPosts() ([]types.BlogPost, error) {
 	var posts []types.BlogPost
 
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			extension.Footnote,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle("solarized-light"),
-				highlighting.WithFormatOptions(
-					chromahtml.WithLineNumbers(true),
-				),
-			),
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),
-			html.WithXHTML(),
-		),
-	)
+	md := newMarkdown()
 
 	blogDir := "blog-posts"
 
@@ -112,28 +124,9 @@ func loadBlogPosts() ([]types.BlogPost, error) {
 }
 
 func loadBlogPost(slug string) (types.BlogPost, error) {
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			extension.Footnote,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle("solarized-light"),
-				highlighting.WithFormatOptions(
-					chromahtml.WithLineNumbers(true),
-				),
-			),
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),
-			html.WithXHTML(),
-		),
-	)
+	md := newMarkdown()
 
 	filePath := filepath.Join("blog-posts", slug+".md")
-	fmt.Println(filePath)
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return types.BlogPost{}, fmt.Errorf("blog post not found: %s", slug)
@@ -164,16 +157,31 @@ func parseMarkdownPost(content, filePath string, md goldmark.Markdown) (types.Bl
 		return types.BlogPost{}, fmt.Errorf("post must have a title on the first line")
 	}
 
-	dateStr := strings.TrimSpace(strings.TrimPrefix(lines[1], "##"))
-	if dateStr == "" {
+	// The date line may carry an optional UTC time and flags, e.g. "## 2025-10-20 14:00 draft"
+	dateFields := strings.Fields(strings.TrimPrefix(lines[1], "##"))
+	if len(dateFields) == 0 {
 		return types.BlogPost{}, fmt.Errorf("post must have a date on the second line")
 	}
 
-	date, err := time.Parse("2006-01-02", dateStr)
+	date, err := time.Parse("2006-01-02", dateFields[0])
 	if err != nil {
 		return types.BlogPost{}, fmt.Errorf("invalid date format: %w", err)
 	}
 
+	var draft bool
+	features := &postFeatures{}
+	for i, field := range dateFields[1:] {
+		if clock, err := time.Parse("15:04", field); err == nil && i == 0 {
+			date = date.Add(time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute)
+			continue
+		}
+		if field == "draft" {
+			draft = true
+		} else if !features.setFlag(field) {
+			return types.BlogPost{}, fmt.Errorf("unknown post flag %q", field)
+		}
+	}
+
 	slug := strings.TrimSuffix(filepath.Base(filePath), ".md")
 
 	contentLines := lines
@@ -184,16 +192,48 @@ func parseMarkdownPost(content, filePath string, md goldmark.Markdown) (types.Bl
 	}
 	contentWithoutHeader := strings.Join(contentLines, "\n")
 
+	source := []byte(contentWithoutHeader)
+	pc := parser.NewContext()
+	pc.Set(postFeaturesKey, features)
+	doc := md.Parser().Parse(text.NewReader(source), parser.WithContext(pc))
+	outline := outlineDocument(doc, source)
+
 	var buf bytes.Buffer
-	if err := md.Convert([]byte(contentWithoutHeader), &buf); err != nil {
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
 		return types.BlogPost{}, fmt.Errorf("failed to convert markdown: %w", err)
 	}
 
 	return types.BlogPost{
-		Slug:     slug,
-		Title:    title,
-		Date:     date,
-		Content:  template.HTML(buf.String()),
-		FilePath: filePath,
+		Slug:        slug,
+		Title:       title,
+		Date:        date,
+		Content:     template.HTML(buf.String()),
+		FilePath:    filePath,
+		Draft:       draft,
+		TOC:         outline.toc,
+		WordCount:   outline.words,
+		ReadingTime: readingTime(outline.words),
+		Description: truncateWords(outline.description, 200),
+		Image:       outline.image,
+
+		Math:          features.usedMath,
+		ClientMath:    features.clientMath,
+		ClientMermaid: features.clientMermaid,
 	}, nil
 }
+
+// isPublished reports whether a post is visible to everyone. Drafts never are,
+// and scheduled posts become visible once their date passes.
+func isPublished(post types.BlogPost, now time.Time) bool {
+	return !post.Draft && !post.Date.After(now)
+}
+
+func publishedPosts(posts []types.BlogPost, now time.Time) []types.BlogPost {
+	var published []types.BlogPost
+	for _, post := range posts {
+		if isPublished(post, now) {
+			published = append(published, post)
+		}
+	}
+	return published
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/imaging"
+	"server/logs"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+)
+
+const (
+	blogAssetDir   = "blog-posts/assets"
+	blogAssetURL   = "/blog/assets/"
+	blogVariantURL = blogAssetURL + "sizes/" // Originals can't contain a slash, so variants never shadow them
+	blogImageSizes = "(max-width: 650px) 100vw, 650px"
+)
+
+// Widths generated for srcset; only those narrower than the original are used
+var blogImageWidths = []int{480, 960, 1440}
+
+type blogImage struct {
+	width   int
+	height  int
+	srcset  string
+	modTime time.Time
+}
+
+var blogImages = struct {
+	sync.Mutex
+	cache map[string]blogImage
+}{cache: make(map[string]blogImage)}
+
+// blogAssetName returns the file name a post's image destination refers to, if it is
+// one of ours. Posts can write either assets/name.png or /blog/assets/name.png.
+func blogAssetName(destination string) (string, bool) {
+	name, found := strings.CutPrefix(destination, "assets/")
+	if !found {
+		name, found = strings.CutPrefix(destination, blogAssetURL)
+	}
+	if !found || name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	return name, true
+}
+
+// loadBlogImage reads the dimensions of a local asset and makes sure its resized
+// variants exist in the cache directory. Results are kept until the file changes.
+func loadBlogImage(name string) (blogImage, error) {
+	path := filepath.Join(blogAssetDir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return blogImage{}, err
+	}
+
+	blogImages.Lock()
+	defer blogImages.Unlock()
+
+	if cached, ok := blogImages.cache[name]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached, nil
+	}
+
+	img, format, err := imaging.Decode(path)
+	if err != nil {
+		return blogImage{}, err
+	}
+	bounds := img.Bounds()
+	result := blogImage{width: bounds.Dx(), height: bounds.Dy(), modTime: info.ModTime()}
+
+	// Resizing would drop every frame but the first
+	if format == "gif" {
+		blogImages.cache[name] = result
+		return result, nil
+	}
+
+	dir := filepath.Join(config.CacheDir, "blog-assets")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return blogImage{}, err
+	}
+
+	var srcset []string
+	for _, width := range blogImageWidths {
+		if width >= result.width {
+			break
+		}
+
+		// The full source name keeps foo.jpg and foo.webp from sharing variants
+		variant := name + "-" + strconv.Itoa(width) + "w" + imaging.Extension(format)
+		variantPath := filepath.Join(dir, variant)
+		if variantInfo, err := os.Stat(variantPath); err != nil || variantInfo.ModTime().Before(info.ModTime()) {
+			if err := imaging.Save(variantPath, imaging.ResizeWidth(img, uint(width)), format); err != nil {
+				return blogImage{}, err
+			}
+		}
+		srcset = append(srcset, fmt.Sprintf("%s%s %dw", blogVariantURL, variant, width))
+	}
+	if len(srcset) > 0 {
+		srcset = append(srcset, fmt.Sprintf("%s%s %dw", blogAssetURL, name, result.width))
+		result.srcset = strings.Join(srcset, ", ")
+	}
+
+	blogImages.cache[name] = result
+	return result, nil
+}
+
+// serveBlogAsset serves originals from blog-posts/assets and generated variants,
+// under blogVariantURL, from the cache.
+func serveBlogAsset(w http.ResponseWriter, r *http.Request) {
+	dir := blogAssetDir
+	name := strings.TrimPrefix(r.URL.Path, blogAssetURL)
+	if variant, found := strings.CutPrefix(r.URL.Path, blogVariantURL); found {
+		dir = filepath.Join(config.CacheDir, "blog-assets")
+		name = variant
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFile(w, r, path)
+}
+
+// blogImageRenderer replaces goldmark's <img> rendering to add lazy loading to every
+// image and srcset, sizes, width and height to local assets.
+type blogImageRenderer struct {
+	html.Config
+}
+
+type blogImageExtension struct{}
+
+func (blogImageExtension) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&blogImageRenderer{Config: html.NewConfig()}, 100),
+	))
+}
+
+func (r *blogImageRenderer) SetOption(name renderer.OptionName, value any) {
+	r.Config.SetOption(name, value)
+}
+
+func (r *blogImageRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindImage, r.renderImage)
+}
+
+func (r *blogImageRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+
+	destination := string(n.Destination)
+	var local blogImage
+	if name, ok := blogAssetName(destination); ok {
+		destination = blogAssetURL + name
+		image, err := loadBlogImage(name)
+		if err != nil {
+			logs.WARN("Failed to load blog image", map[string]any{"name": name, "error": err.Error()})
+		}
+		local = image
+	}
+
+	w.WriteString(`<img src="`)
+	if r.Unsafe || !html.IsDangerousURL([]byte(destination)) {
+		w.Write(util.EscapeHTML(util.URLEscape([]byte(destination), true)))
+	}
+	w.WriteString(`" alt="`)
+	var alt strings.Builder
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		alt.WriteString(nodeText(child, source))
+	}
+	w.Write(util.EscapeHTML([]byte(alt.String())))
+	w.WriteByte('"')
+
+	if n.Title != nil {
+		w.WriteString(` title="`)
+		w.Write(util.EscapeHTML(n.Title))
+		w.WriteByte('"')
+	}
+
+	if local.width > 0 {
+		fmt.Fprintf(w, ` width="%d" height="%d"`, local.width, local.height)
+	}
+	if local.srcset != "" {
+		w.WriteString(` srcset="`)
+		w.Write(util.EscapeHTML([]byte(local.srcset)))
+		w.WriteString(`" sizes="` + blogImageSizes + `"`)
+	}
+	w.WriteString(` loading="lazy" decoding="async"`)
+
+	if n.Attributes() != nil {
+		html.RenderAttributes(w, n, html.ImageAttributeFilter)
+	}
+	if r.XHTML {
+		w.WriteString(" />")
+	} else {
+		w.WriteString(">")
+	}
+	return ast.WalkSkipChildren, nil
+}
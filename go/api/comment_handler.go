@@ -0,0 +1,328 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"server/db"
+	"server/logs"
+	"server/types"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	commentMaxName   = 50
+	commentMaxBody   = 4000
+	commentRateLimit = 3
+	commentRateSpan  = 10 * time.Minute
+)
+
+var (
+	commentQuoteRef = regexp.MustCompile(`&gt;&gt;(\d+)`)
+	commentCode     = regexp.MustCompile("`([^`\n]+)`")
+	commentBold     = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	commentItalic   = regexp.MustCompile(`\*([^*\n]+)\*`)
+	commentLink     = regexp.MustCompile(`https?://(?:[^\s&<\x00]|&amp;)+`)
+
+	commentLimiter = struct {
+		sync.Mutex
+		hits map[string][]time.Time
+	}{hits: make(map[string][]time.Time)}
+)
+
+// CommentHandler takes the comment form from blog-post.html. Comments are held for
+// moderation, so a successful post only redirects back with a notice.
+func CommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	slug := r.PostForm.Get("slug")
+	post, err := loadBlogPost(slug)
+	if err != nil || !isPublished(post, time.Now()) {
+		http.Error(w, "Unknown blog post", http.StatusBadRequest)
+		return
+	}
+	redirect := "/blog/" + url.PathEscape(slug) + "?comment=sent#comments"
+
+	// Humans never see the website field, so anything in it came from a bot.
+	// Pretend it worked so the bot has no reason to adapt.
+	if r.PostForm.Get("website") != "" {
+		logs.INFO("Dropped honeypot comment", map[string]any{"slug": slug, "remote_addr": logs.StoredIP(logs.ClientIP(r))})
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	name := strings.Join(strings.Fields(r.PostForm.Get("name")), " ")
+	if name == "" {
+		name = "Anonymous"
+	}
+	body := strings.TrimSpace(strings.ReplaceAll(r.PostForm.Get("body"), "\r\n", "\n"))
+	if body == "" {
+		http.Error(w, "Comment is empty", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(name) > commentMaxName || utf8.RuneCountInString(body) > commentMaxBody {
+		http.Error(w, fmt.Sprintf("Names are limited to %d characters and comments to %d", commentMaxName, commentMaxBody), http.StatusBadRequest)
+		return
+	}
+
+	var parentID sql.NullInt64
+	if parent := r.PostForm.Get("parent"); parent != "" {
+		id, err := strconv.Atoi(parent)
+		if err != nil {
+			http.Error(w, "Invalid parent comment", http.StatusBadRequest)
+			return
+		}
+		var parentSlug string
+		err = db.DB.QueryRow("SELECT slug FROM comments WHERE id = ? AND status = 'approved'", id).Scan(&parentSlug)
+		if err != nil || parentSlug != slug {
+			http.Error(w, "Invalid parent comment", http.StatusBadRequest)
+			return
+		}
+		parentID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	// Only comments that would be saved use up the sender's allowance
+	if !allowComment(logs.TrustedClientIP(r), time.Now()) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(commentRateSpan.Seconds())))
+		http.Error(w, "Too many comments, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT INTO comments (slug, parent_id, name, body, remote_addr, user_agent, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, slug, parentID, name, body, logs.StoredIP(logs.ClientIP(r)), logs.StoredUserAgent(r), time.Now().UTC())
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to save comment")
+		return
+	}
+
+	logs.INFO("New comment awaiting moderation", map[string]any{"slug": slug, "name": name})
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// allowComment is a sliding window of commentRateLimit comments per commentRateSpan per IP.
+func allowComment(ip string, now time.Time) bool {
+	commentLimiter.Lock()
+	defer commentLimiter.Unlock()
+
+	for key, hits := range commentLimiter.hits {
+		if now.Sub(hits[len(hits)-1]) > commentRateSpan {
+			delete(commentLimiter.hits, key)
+		}
+	}
+
+	var recent []time.Time
+	for _, hit := range commentLimiter.hits[ip] {
+		if now.Sub(hit) <= commentRateSpan {
+			recent = append(recent, hit)
+		}
+	}
+	if len(recent) >= commentRateLimit {
+		commentLimiter.hits[ip] = recent
+		return false
+	}
+
+	commentLimiter.hits[ip] = append(recent, now)
+	return true
+}
+
+// loadComments returns the approved comments on a post as a tree. Replies whose
+// parent is no longer approved are shown at the top level.
+func loadComments(slug string) ([]types.Comment, error) {
+	comments, err := queryComments(`
+		SELECT id, slug, parent_id, name, body, status, created
+		FROM comments
+		WHERE slug = ? AND status = 'approved'
+		ORDER BY created ASC
+	`, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	approved := make(map[int]bool)
+	children := make(map[int][]types.Comment)
+	for _, comment := range comments {
+		approved[comment.ID] = true
+	}
+	for i := range comments {
+		comments[i].HTML = renderComment(comments[i].Body, approved)
+		if !approved[comments[i].ParentID] {
+			comments[i].ParentID = 0
+		}
+		children[comments[i].ParentID] = append(children[comments[i].ParentID], comments[i])
+	}
+
+	return commentTree(children, 0), nil
+}
+
+func commentTree(children map[int][]types.Comment, parent int) []types.Comment {
+	thread := children[parent]
+	for i := range thread {
+		thread[i].Replies = commentTree(children, thread[i].ID)
+	}
+	return thread
+}
+
+func queryComments(query string, args ...any) ([]types.Comment, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []types.Comment
+	for rows.Next() {
+		var comment types.Comment
+		var parentID sql.NullInt64
+		err := rows.Scan(&comment.ID, &comment.Slug, &parentID, &comment.Name, &comment.Body, &comment.Status, &comment.Created)
+		if err != nil {
+			return nil, err
+		}
+		comment.ParentID = int(parentID.Int64)
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// renderComment escapes a comment and applies the small markdown subset comments
+// support: `code`, **bold**, *italic*, bare links, > quote lines and >>id
+// references, which link to the comment like the petrarchive's >>id post links.
+// Code spans and links are swapped for \x00n\x00 placeholders while the other
+// passes run, so nothing rewrites inside them.
+func renderComment(body string, approved map[int]bool) template.HTML {
+	// Placeholders use NUL, so none can be typed into the comment
+	body = strings.ReplaceAll(body, "\x00", "")
+	lines := strings.Split(template.HTMLEscapeString(body), "\n")
+
+	for i, line := range lines {
+		var snippets []string
+		hold := func(snippet string) string {
+			snippets = append(snippets, snippet)
+			return "\x00" + strconv.Itoa(len(snippets)-1) + "\x00"
+		}
+
+		line = commentCode.ReplaceAllStringFunc(line, func(match string) string {
+			return hold("<code>" + commentCode.FindStringSubmatch(match)[1] + "</code>")
+		})
+		line = commentLink.ReplaceAllStringFunc(line, func(match string) string {
+			trimmed := strings.TrimRight(match, ".,;:!?)")
+			return hold(fmt.Sprintf(`<a href="%s" rel="nofollow ugc noopener">%s</a>`, trimmed, trimmed)) + match[len(trimmed):]
+		})
+
+		line = commentBold.ReplaceAllString(line, "<strong>$1</strong>")
+		line = commentItalic.ReplaceAllString(line, "<em>$1</em>")
+
+		line = commentQuoteRef.ReplaceAllStringFunc(line, func(match string) string {
+			id, _ := strconv.Atoi(commentQuoteRef.FindStringSubmatch(match)[1])
+			if !approved[id] {
+				return fmt.Sprintf(`<span class="post-ref dead-link" title="Comment not found">%s</span>`, match)
+			}
+			return fmt.Sprintf(`<a href="#comment-%d" class="post-ref">%s</a>`, id, match)
+		})
+
+		if strings.HasPrefix(line, "&gt;") && !strings.HasPrefix(line, "<") {
+			line = `<span class="quote">` + line + `</span>`
+		}
+
+		for j, snippet := range snippets {
+			line = strings.Replace(line, "\x00"+strconv.Itoa(j)+"\x00", snippet, 1)
+		}
+		lines[i] = line
+	}
+
+	return template.HTML(strings.Join(lines, "<br>"))
+}
+
+// CommentAdminHandler is the moderation API for comments, shaped like WebmentionAdminHandler.
+func CommentAdminHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		status := r.URL.Query().Get("moderation")
+		if status == "" {
+			status = "pending"
+		}
+
+		comments, err := queryComments(`
+			SELECT id, slug, parent_id, name, body, status, created
+			FROM comments
+			WHERE status = ?
+			ORDER BY created DESC
+			LIMIT 200
+		`, status)
+		if err != nil {
+			logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to list comments")
+			return
+		}
+		if comments == nil {
+			comments = []types.Comment{}
+		}
+		json.NewEncoder(w).Encode(comments)
+
+	case http.MethodPost:
+		var update struct {
+			ID         int    `json:"id"`
+			Moderation string `json:"moderation"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if update.Moderation != "pending" && update.Moderation != "approved" && update.Moderation != "rejected" {
+			http.Error(w, "moderation must be pending, approved or rejected", http.StatusBadRequest)
+			return
+		}
+
+		result, err := db.DB.Exec("UPDATE comments SET status = ? WHERE id = ?", update.Moderation, update.ID)
+		if err != nil {
+			logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to moderate comment")
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		logs.HTTPSuccess(w, r, "Comment "+update.Moderation)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+			return
+		}
+
+		// Replies are kept and move up to the top level
+		_, err = db.DB.Exec("UPDATE comments SET parent_id = NULL WHERE parent_id = ?", id)
+		if err == nil {
+			_, err = db.DB.Exec("DELETE FROM comments WHERE id = ?", id)
+		}
+		if err != nil {
+			logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to delete comment")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
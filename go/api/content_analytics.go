@@ -0,0 +1,302 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"server/db"
+	"server/logs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ContentAnalytics struct {
+	Range TimeRange      `json:"range"`
+	Days  []string       `json:"days"`
+	Items []ContentStats `json:"items"`
+}
+
+type ContentStats struct {
+	Kind          string          `json:"kind"` // blog, thread or page
+	ID            string          `json:"id"`
+	Title         string          `json:"title"`
+	URL           string          `json:"url"`
+	Views         int             `json:"views"`
+	Visitors      int             `json:"visitors"`
+	Daily         []int           `json:"daily"`
+	DailyVisitors []int           `json:"dailyVisitors"`
+	Referrers     []ReferrerCount `json:"referrers"`
+
+	visitors      map[string]bool
+	dailyVisitors []map[string]bool
+	referrers     map[string]int
+}
+
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Count    int    `json:"count"`
+}
+
+// First path segments of the app pages that count as content
+var analyticsPages = map[string]bool{
+	"": true, "blog": true, "search": true, "petrarchive": true, "splits": true, "spirals": true,
+	"reverse-wordle-solver": true, "hexagons": true, "l8": true, "ebwg": true,
+}
+
+// contentFor maps a logged request URL onto the piece of content it viewed.
+func contentFor(rawURL string) (kind, id string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", false
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+
+	if slug, found := strings.CutPrefix(path, "/blog/"); found {
+		if strings.HasPrefix(path, blogAssetURL) || slug == "" || strings.Contains(slug, "/") {
+			return "", "", false
+		}
+		return "blog", slug, true
+	}
+
+	if threadID, found := strings.CutPrefix(path, "/petrarchive/thread/"); found {
+		if _, err := strconv.Atoi(threadID); err != nil {
+			return "", "", false
+		}
+		return "thread", threadID, true
+	}
+
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !analyticsPages[segment] {
+		return "", "", false
+	}
+	return "page", "/" + segment, true
+}
+
+// ContentAnalyticsHandler reports page views per blog post, archive thread and app
+// page. It takes the dashboard's time range and filters, and without them counts
+// successful GETs from non-bot user agents.
+func ContentAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logs.HTTPError(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	timeRange, err := parseTimeRange(r, "30d")
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.From, filter.To = timeRange.From, timeRange.To
+
+	kindFilter := r.URL.Query().Get("kind")
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	data, err := getContentAnalytics(timeRange, filter, kindFilter, limit)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get content analytics")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func ContentAnalyticsPageHandler(w http.ResponseWriter, r *http.Request) {
+	ServeTemplate(w, r, "content_analytics.html", nil)
+}
+
+func getContentAnalytics(timeRange TimeRange, filter Filter, kindFilter string, limit int) (ContentAnalytics, error) {
+	data := ContentAnalytics{Range: timeRange, Items: []ContentStats{}}
+
+	dayIndex := make(map[string]int)
+	for day := timeRange.From.Truncate(24 * time.Hour); day.Before(timeRange.To); day = day.Add(24 * time.Hour) {
+		dayIndex[day.Format("2006-01-02")] = len(data.Days)
+		data.Days = append(data.Days, day.Format("2006-01-02"))
+	}
+
+	// Views are successful GETs by people unless the filter asks for something else
+	where := filter.where()
+	if filter.Method == "" {
+		where = where.and("method = 'GET'")
+	}
+	if filter.Status == "" {
+		where = where.and("status_code < 400")
+	}
+	if filter.UAClass == "" {
+		where = where.and(humansCondition)
+	}
+
+	// One row per visitor per URL per day; the URLs are mapped onto content in Go
+	pages := where.and("url NOT LIKE '/static/%' AND url NOT LIKE '/api/%'")
+	rows, err := db.DB.Query(`
+		SELECT substr(timestamp, 1, 10), url, remote_addr, COUNT(*)
+		FROM access_logs
+		WHERE `+pages.String()+`
+		GROUP BY 1, 2, 3
+	`, pages.params()...)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+
+	items := make(map[string]*ContentStats)
+	itemFor := func(rawURL string) *ContentStats {
+		kind, id, ok := contentFor(rawURL)
+		if !ok || (kindFilter != "" && kind != kindFilter) {
+			return nil
+		}
+
+		key := kind + ":" + id
+		item := items[key]
+		if item == nil {
+			item = &ContentStats{
+				Kind:          kind,
+				ID:            id,
+				Daily:         make([]int, len(data.Days)),
+				DailyVisitors: make([]int, len(data.Days)),
+				visitors:      make(map[string]bool),
+				dailyVisitors: make([]map[string]bool, len(data.Days)),
+				referrers:     make(map[string]int),
+			}
+			items[key] = item
+		}
+		return item
+	}
+
+	for rows.Next() {
+		var day, rawURL, remoteAddr string
+		var views int
+		if err := rows.Scan(&day, &rawURL, &remoteAddr, &views); err != nil {
+			return data, err
+		}
+		item := itemFor(rawURL)
+		if item == nil {
+			continue
+		}
+
+		item.Views += views
+		item.visitors[remoteAddr] = true
+		if i, ok := dayIndex[day]; ok {
+			item.Daily[i] += views
+			if item.dailyVisitors[i] == nil {
+				item.dailyVisitors[i] = make(map[string]bool)
+			}
+			item.dailyVisitors[i][remoteAddr] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return data, err
+	}
+
+	referred := where.and("COALESCE(referrer, '') != ''")
+	referrers, err := db.DB.Query(`
+		SELECT url, referrer, COUNT(*)
+		FROM access_logs
+		WHERE `+referred.String()+`
+		GROUP BY url, referrer
+	`, referred.params()...)
+	if err != nil {
+		return data, err
+	}
+	defer referrers.Close()
+
+	for referrers.Next() {
+		var rawURL, referrer string
+		var count int
+		if err := referrers.Scan(&rawURL, &referrer, &count); err != nil {
+			return data, err
+		}
+		kind, id, ok := contentFor(rawURL)
+		if item := items[kind+":"+id]; ok && item != nil {
+			item.referrers[referrer] += count
+		}
+	}
+	if err := referrers.Err(); err != nil {
+		return data, err
+	}
+
+	for _, item := range items {
+		item.Visitors = len(item.visitors)
+		for day, visitors := range item.dailyVisitors {
+			item.DailyVisitors[day] = len(visitors)
+		}
+		item.Referrers = topReferrers(item.referrers, 10)
+		data.Items = append(data.Items, *item)
+	}
+
+	sort.Slice(data.Items, func(i, j int) bool {
+		if data.Items[i].Views != data.Items[j].Views {
+			return data.Items[i].Views > data.Items[j].Views
+		}
+		return data.Items[i].URL < data.Items[j].URL
+	})
+	if len(data.Items) > limit {
+		data.Items = data.Items[:limit]
+	}
+
+	return data, labelContent(data.Items)
+}
+
+func topReferrers(counts map[string]int, limit int) []ReferrerCount {
+	referrers := []ReferrerCount{}
+	for referrer, count := range counts {
+		referrers = append(referrers, ReferrerCount{Referrer: referrer, Count: count})
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		if referrers[i].Count != referrers[j].Count {
+			return referrers[i].Count > referrers[j].Count
+		}
+		return referrers[i].Referrer < referrers[j].Referrer
+	})
+	if len(referrers) > limit {
+		referrers = referrers[:limit]
+	}
+	return referrers
+}
+
+// labelContent fills in the titles and canonical URLs of analytics items.
+func labelContent(items []ContentStats) error {
+	titles := make(map[string]string)
+	if posts, err := loadBlogPosts(); err == nil {
+		for _, post := range posts {
+			titles[post.Slug] = post.Title
+		}
+	}
+
+	for i := range items {
+		item := &items[i]
+		switch item.Kind {
+		case "blog":
+			item.URL = "/blog/" + item.ID
+			item.Title = titles[item.ID]
+		case "thread":
+			item.URL = "/petrarchive/thread/" + item.ID
+			var title sql.NullString
+			err := db.DB.QueryRow("SELECT title FROM posts WHERE thread = ? AND thread_owner = 1", item.ID).Scan(&title)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			item.Title = title.String
+		default:
+			item.URL = item.ID
+		}
+		if item.Title == "" {
+			item.Title = item.URL
+		}
+	}
+	return nil
+}
@@ -5,25 +5,68 @@ import (
 	"encoding/json"
 	"net/http"
 	"server/db"
+	"server/geoip"
 	"server/logs"
 	"strconv"
 	"strings"
 )
 
+// Requests from clients not classified as bots. Rows from before classification
+// existed are backfilled at startup and count as human until then.
+const humansCondition = "COALESCE(ua_device, '') != 'bot'"
+
+// Labels networks like "AS13335 Cloudflare, Inc."
+const networkLabel = "'AS' || asn || COALESCE(' ' || as_org, '')"
+
 type DashboardData struct {
+	Range      TimeRange      `json:"range"`
 	Stats      DashboardStats `json:"stats"`
+	Series     []SeriesPoint  `json:"series"`
+	Routes     []RouteLatency `json:"routeLatency"`
+	Slowest    []SlowRequest  `json:"slowRequests"`
 	TopIPs     []IPCount      `json:"topIPs"`
 	TopRoutes  []RouteCount   `json:"topRoutes"`
 	Bot404s    []IPCount      `json:"bot404s"`
 	ErrorCodes []StatusCount  `json:"errorCodes"`
 	UserAgents []UACount      `json:"userAgents"`
+	Sources    TrafficSources `json:"sources"`
+	Clients    ClientCounts   `json:"clients"`
+	Countries  []NameCount    `json:"countries"`
+	Networks   []NameCount    `json:"networks"`
+	GeoIP      bool           `json:"geoip"`
+}
+
+type ClientCounts struct {
+	Browsers         []NameCount `json:"browsers"`
+	OperatingSystems []NameCount `json:"operatingSystems"`
+	Devices          []NameCount `json:"devices"`
+	Crawlers         []NameCount `json:"crawlers"`
+}
+
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
 }
 
 type DashboardStats struct {
-	TotalRequests   int     `json:"totalRequests"`
-	UniqueIPs       int     `json:"uniqueIPs"`
-	ErrorRate       float64 `json:"errorRate"`
-	AvgResponseTime float64 `json:"avgResponseTime"`
+	TotalRequests   int         `json:"totalRequests"`
+	UniqueIPs       int         `json:"uniqueIPs"`
+	ErrorRate       float64     `json:"errorRate"`
+	AvgResponseTime float64     `json:"avgResponseTime"`
+	Latency         Percentiles `json:"latency"`
+
+	// Set on the current period only, comparing it with the one just before it
+	Previous *DashboardStats `json:"previous,omitempty"`
+	Deltas   *StatsDeltas    `json:"deltas,omitempty"`
+}
+
+// Percent changes, except ErrorRate which is the change in percentage points.
+// Percent changes are null when the previous period had none.
+type StatsDeltas struct {
+	TotalRequests   *float64 `json:"totalRequests"`
+	UniqueIPs       *float64 `json:"uniqueIPs"`
+	ErrorRate       float64  `json:"errorRate"`
+	AvgResponseTime *float64 `json:"avgResponseTime"`
 }
 
 type IPCount struct {
@@ -42,12 +85,17 @@ type StatusCount struct {
 }
 
 type IPAnalyticsData struct {
-	Stats          IPStats       `json:"stats"`
-	TopRoutes      []RouteCount  `json:"topRoutes"`
-	StatusCodes    []StatusCount `json:"statusCodes"`
-	HourlyActivity []HourCount   `json:"hourlyActivity"`
-	UserAgents     []UACount     `json:"userAgents"`
-	AccessLogs     []AccessLog   `json:"accessLogs"`
+	Stats          IPStats        `json:"stats"`
+	Location       geoip.Location `json:"location"`
+	Countries      []NameCount    `json:"countries"`
+	Networks       []NameCount    `json:"networks"`
+	GeoIP          bool           `json:"geoip"`
+	TopRoutes      []RouteCount   `json:"topRoutes"`
+	StatusCodes    []StatusCount  `json:"statusCodes"`
+	HourlyActivity []HourCount    `json:"hourlyActivity"`
+	UserAgents     []UACount      `json:"userAgents"`
+	AccessLogs     []AccessLog    `json:"accessLogs"`
+	Sessions       []Session      `json:"sessions"`
 }
 
 type IPStats struct {
@@ -86,92 +134,145 @@ func DashboardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	period := r.URL.Query().Get("period")
-	if period == "" {
-		period = "24h"
+	timeRange, err := parseTimeRange(r, "24h")
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	var timeCondition string
-	switch period {
-	case "1h":
-		timeCondition = "timestamp >= datetime('now', '-1 hour')"
-	case "24h":
-		timeCondition = "timestamp >= datetime('now', '-1 day')"
-	case "7d":
-		timeCondition = "timestamp >= datetime('now', '-7 days')"
-	case "30d":
-		timeCondition = "timestamp >= datetime('now', '-30 days')"
-	default:
-		timeCondition = "timestamp >= datetime('now', '-1 day')"
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.URL.Query().Get("humans") == "true" && filter.UAClass == "" {
+		filter.UAClass = "human"
 	}
+	filter.From, filter.To = timeRange.From, timeRange.To
+	previousFilter := filter
+	previousFilter.From, previousFilter.To = timeRange.previous().From, timeRange.previous().To
 
-	data := DashboardData{}
+	data := DashboardData{Range: timeRange}
 
-	stats, err := getDashboardStats(timeCondition)
+	stats, err := getDashboardStats(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get dashboard stats")
 		return
 	}
+	previous, err := getDashboardStats(previousFilter)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get previous period stats")
+		return
+	}
+	stats.Previous = &previous
+	stats.Deltas = &StatsDeltas{
+		TotalRequests:   percentChange(float64(previous.TotalRequests), float64(stats.TotalRequests)),
+		UniqueIPs:       percentChange(float64(previous.UniqueIPs), float64(stats.UniqueIPs)),
+		ErrorRate:       stats.ErrorRate - previous.ErrorRate,
+		AvgResponseTime: percentChange(previous.AvgResponseTime, stats.AvgResponseTime),
+	}
 	data.Stats = stats
 
-	topIPs, err := getTopIPs(timeCondition, 100)
+	series, err := getSeries(timeRange, filter)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get time series")
+		return
+	}
+	data.Series = series
+
+	if data.Routes, err = getRouteLatencies(filter, 5, 50); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get route latency")
+		return
+	}
+	if data.Slowest, err = getSlowRequests(filter, 50); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get slow requests")
+		return
+	}
+
+	topIPs, err := getTopIPs(filter, 100)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get top IPs")
 		return
 	}
 	data.TopIPs = topIPs
 
-	topRoutes, err := getTopRoutes(timeCondition, 100)
+	topRoutes, err := getTopRoutes(filter, 100)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get top routes")
 		return
 	}
 	data.TopRoutes = topRoutes
 
-	bot404s, err := getBot404s(timeCondition, 100)
+	bot404s, err := getBot404s(filter, 100)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get bot 404s")
 		return
 	}
 	data.Bot404s = bot404s
 
-	errorCodes, err := getErrorCodes(timeCondition)
+	errorCodes, err := getErrorCodes(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get error codes")
 		return
 	}
 	data.ErrorCodes = errorCodes
 
-	userAgents, err := getTopUserAgents(timeCondition, 20)
+	userAgents, err := getTopUserAgents(filter, 20)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get user agents")
 		return
 	}
 	data.UserAgents = userAgents
 
+	sources, err := getTrafficSources(filter, 20)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get traffic sources")
+		return
+	}
+	data.Sources = sources
+
+	clients, err := getClientCounts(filter, 20)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get client breakdowns")
+		return
+	}
+	data.Clients = clients
+
+	data.GeoIP = geoip.Enabled()
+	if data.Countries, err = getNameCounts("country", filter.where().and("country IS NOT NULL"), 20); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get countries")
+		return
+	}
+	if data.Networks, err = getNameCounts(networkLabel, filter.where().and("asn IS NOT NULL"), 20); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get networks")
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(data)
 }
 
-func getDashboardStats(timeCondition string) (DashboardStats, error) {
+func getDashboardStats(filter Filter) (DashboardStats, error) {
 	var stats DashboardStats
+	where := filter.where()
 
-	query := "SELECT COUNT(*) FROM access_logs WHERE " + timeCondition
-	err := db.DB.QueryRow(query).Scan(&stats.TotalRequests)
+	query := "SELECT COUNT(*) FROM access_logs WHERE " + where.String()
+	err := db.DB.QueryRow(query, where.params()...).Scan(&stats.TotalRequests)
 	if err != nil {
 		return stats, err
 	}
 
-	query = "SELECT COUNT(DISTINCT remote_addr) FROM access_logs WHERE " + timeCondition
-	err = db.DB.QueryRow(query).Scan(&stats.UniqueIPs)
+	query = "SELECT COUNT(DISTINCT remote_addr) FROM access_logs WHERE " + where.String()
+	err = db.DB.QueryRow(query, where.params()...).Scan(&stats.UniqueIPs)
 	if err != nil {
 		return stats, err
 	}
 
 	var errorCount int
-	query = "SELECT COUNT(*) FROM access_logs WHERE " + timeCondition + " AND status_code >= 400"
-	err = db.DB.QueryRow(query).Scan(&errorCount)
+	failed := where.and("status_code >= 400")
+	query = "SELECT COUNT(*) FROM access_logs WHERE " + failed.String()
+	err = db.DB.QueryRow(query, failed.params()...).Scan(&errorCount)
 	if err != nil {
 		return stats, err
 	}
@@ -180,9 +281,9 @@ func getDashboardStats(timeCondition string) (DashboardStats, error) {
 		stats.ErrorRate = (float64(errorCount) / float64(stats.TotalRequests)) * 100
 	}
 
-	query = "SELECT AVG(response_time) FROM access_logs WHERE " + timeCondition + " AND response_time > 0"
+	query = "SELECT AVG(" + durationMs + ") FROM access_logs WHERE " + where.String()
 	var avgTime sql.NullFloat64
-	err = db.DB.QueryRow(query).Scan(&avgTime)
+	err = db.DB.QueryRow(query, where.params()...).Scan(&avgTime)
 	if err != nil {
 		return stats, err
 	}
@@ -190,19 +291,21 @@ func getDashboardStats(timeCondition string) (DashboardStats, error) {
 		stats.AvgResponseTime = avgTime.Float64
 	}
 
-	return stats, nil
+	stats.Latency, err = getLatencyPercentiles(filter)
+	return stats, err
 }
 
-func getTopIPs(timeCondition string, limit int) ([]IPCount, error) {
+func getTopIPs(filter Filter, limit int) ([]IPCount, error) {
+	where := filter.where()
 	query := `
 		SELECT remote_addr, COUNT(*) as count
 		FROM access_logs
-		WHERE ` + timeCondition + `
+		WHERE ` + where.String() + `
 		GROUP BY remote_addr
 		ORDER BY count DESC
 		LIMIT ?`
 
-	rows, err := db.DB.Query(query, limit)
+	rows, err := db.DB.Query(query, where.params(limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -221,16 +324,17 @@ func getTopIPs(timeCondition string, limit int) ([]IPCount, error) {
 	return results, nil
 }
 
-func getTopRoutes(timeCondition string, limit int) ([]RouteCount, error) {
+func getTopRoutes(filter Filter, limit int) ([]RouteCount, error) {
+	where := filter.where()
 	query := `
 		SELECT url, COUNT(*) as count
 		FROM access_logs
-		WHERE ` + timeCondition + `
+		WHERE ` + where.String() + `
 		GROUP BY url
 		ORDER BY count DESC
 		LIMIT ?`
 
-	rows, err := db.DB.Query(query, limit)
+	rows, err := db.DB.Query(query, where.params(limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -249,17 +353,18 @@ func getTopRoutes(timeCondition string, limit int) ([]RouteCount, error) {
 	return results, nil
 }
 
-func getBot404s(timeCondition string, limit int) ([]IPCount, error) {
+func getBot404s(filter Filter, limit int) ([]IPCount, error) {
+	where := filter.where().and("status_code = 404")
 	query := `
 		SELECT remote_addr, COUNT(*) as count
 		FROM access_logs
-		WHERE ` + timeCondition + ` AND status_code = 404
+		WHERE ` + where.String() + `
 		GROUP BY remote_addr
 		HAVING count >= 5
 		ORDER BY count DESC
 		LIMIT ?`
 
-	rows, err := db.DB.Query(query, limit)
+	rows, err := db.DB.Query(query, where.params(limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -278,15 +383,16 @@ func getBot404s(timeCondition string, limit int) ([]IPCount, error) {
 	return results, nil
 }
 
-func getErrorCodes(timeCondition string) ([]StatusCount, error) {
+func getErrorCodes(filter Filter) ([]StatusCount, error) {
+	where := filter.where().and("status_code >= 400")
 	query := `
 		SELECT status_code, COUNT(*) as count
 		FROM access_logs
-		WHERE ` + timeCondition + ` AND status_code >= 400
+		WHERE ` + where.String() + `
 		GROUP BY status_code
 		ORDER BY count DESC`
 
-	rows, err := db.DB.Query(query)
+	rows, err := db.DB.Query(query, where.params()...)
 	if err != nil {
 		return nil, err
 	}
@@ -305,16 +411,22 @@ func getErrorCodes(timeCondition string) ([]StatusCount, error) {
 	return results, nil
 }
 
-func getTopUserAgents(timeCondition string, limit int) ([]UACount, error) {
+// getTopUserAgents groups requests by crawler, or by browser major version and OS.
+func getTopUserAgents(filter Filter, limit int) ([]UACount, error) {
+	where := filter.where()
 	query := `
-		SELECT COALESCE(user_agent, 'Unknown') as user_agent, COUNT(*) as count
+		SELECT
+			CASE WHEN COALESCE(ua_crawler, '') != '' THEN ua_crawler
+			ELSE COALESCE(ua_browser, 'Unknown') || COALESCE(' ' || NULLIF(ua_version, ''), '') || ' / ' || COALESCE(ua_os, 'Unknown')
+			END as client,
+			COUNT(*) as count
 		FROM access_logs
-		WHERE ` + timeCondition + `
-		GROUP BY user_agent
+		WHERE ` + where.String() + `
+		GROUP BY client
 		ORDER BY count DESC
 		LIMIT ?`
 
-	rows, err := db.DB.Query(query, limit)
+	rows, err := db.DB.Query(query, where.params(limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -333,6 +445,53 @@ func getTopUserAgents(timeCondition string, limit int) ([]UACount, error) {
 	return results, nil
 }
 
+func getClientCounts(filter Filter, limit int) (ClientCounts, error) {
+	var counts ClientCounts
+	var err error
+	where := filter.where()
+
+	if counts.Browsers, err = getNameCounts("ua_browser", where.and(humansCondition), limit); err != nil {
+		return counts, err
+	}
+	if counts.OperatingSystems, err = getNameCounts("ua_os", where.and(humansCondition), limit); err != nil {
+		return counts, err
+	}
+	if counts.Devices, err = getNameCounts("ua_device", where, limit); err != nil {
+		return counts, err
+	}
+	counts.Crawlers, err = getNameCounts("ua_crawler", where.and("ua_crawler != ''"), limit)
+	return counts, err
+}
+
+// getNameCounts counts requests by the value of a column or expression, which is
+// always one of ours and never user input.
+func getNameCounts(column string, where sqlWhere, limit int) ([]NameCount, error) {
+	query := `
+		SELECT COALESCE(` + column + `, 'Unknown') as name, COUNT(*) as count
+		FROM access_logs
+		WHERE ` + where.String() + `
+		GROUP BY name
+		ORDER BY count DESC
+		LIMIT ?`
+
+	rows, err := db.DB.Query(query, where.params(limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []NameCount
+	for rows.Next() {
+		var result NameCount
+		if err := rows.Scan(&result.Name, &result.Count); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
 func DashboardPageHandler(w http.ResponseWriter, r *http.Request) {
 	ServeTemplate(w, r, "dashboard.html", nil)
 }
@@ -354,70 +513,85 @@ func IPAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
 		logs.HTTPError(w, r, nil, http.StatusBadRequest, "IP address required")
 		return
 	}
+	// Addresses typed in by hand are matched the way they are stored today
+	lookupIP := ip
+	ip = logs.StoredIP(ip)
 
-	period := r.URL.Query().Get("period")
-	if period == "" {
-		period = "24h"
+	timeRange, err := parseTimeRange(r, "24h")
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
 	}
-
-	var timeCondition string
-	switch period {
-	case "1h":
-		timeCondition = "timestamp >= datetime('now', '-1 hour')"
-	case "24h":
-		timeCondition = "timestamp >= datetime('now', '-1 day')"
-	case "7d":
-		timeCondition = "timestamp >= datetime('now', '-7 days')"
-	case "30d":
-		timeCondition = "timestamp >= datetime('now', '-30 days')"
-	default:
-		timeCondition = "timestamp >= datetime('now', '-1 day')"
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
 	}
+	filter.IP = ip
+	filter.From, filter.To = timeRange.From, timeRange.To
 
 	data := IPAnalyticsData{}
 
-	stats, err := getIPStats(ip, timeCondition)
+	stats, err := getIPStats(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP stats")
 		return
 	}
 	data.Stats = stats
 
-	topRoutes, err := getIPTopRoutes(ip, timeCondition)
+	topRoutes, err := getIPTopRoutes(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP routes")
 		return
 	}
 	data.TopRoutes = topRoutes
 
-	statusCodes, err := getIPStatusCodes(ip, timeCondition)
+	statusCodes, err := getIPStatusCodes(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP status codes")
 		return
 	}
 	data.StatusCodes = statusCodes
 
-	hourlyActivity, err := getIPHourlyActivity(ip, timeCondition)
+	hourlyActivity, err := getIPHourlyActivity(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP hourly activity")
 		return
 	}
 	data.HourlyActivity = hourlyActivity
 
-	userAgents, err := getIPUserAgents(ip, timeCondition)
+	userAgents, err := getIPUserAgents(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP user agents")
 		return
 	}
 	data.UserAgents = userAgents
 
-	accessLogs, err := getIPAccessLogs(ip, timeCondition)
+	accessLogs, err := getIPAccessLogs(filter)
 	if err != nil {
 		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP access logs")
 		return
 	}
 	data.AccessLogs = accessLogs
 
+	if data.Sessions, err = getSessions(filter); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP sessions")
+		return
+	}
+
+	// The live lookup covers requests logged before GeoIP was configured; the
+	// breakdowns show what was recorded at the time, which differs if the address moved
+	data.GeoIP = geoip.Enabled()
+	data.Location = geoip.Lookup(lookupIP)
+	if data.Countries, err = getNameCounts("country", filter.where().and("country IS NOT NULL"), 20); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP countries")
+		return
+	}
+	if data.Networks, err = getNameCounts(networkLabel, filter.where().and("asn IS NOT NULL"), 20); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get IP networks")
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(data)
@@ -445,30 +619,32 @@ func IPAnalyticsPageHandler(w http.ResponseWriter, r *http.Request) {
 	ServeTemplate(w, r, "ip_analytics.html", data)
 }
 
-func getIPStats(ip, timeCondition string) (IPStats, error) {
+func getIPStats(filter Filter) (IPStats, error) {
 	var stats IPStats
+	where := filter.where()
 
-	query := "SELECT COUNT(*) FROM access_logs WHERE remote_addr = ? AND " + timeCondition
-	err := db.DB.QueryRow(query, ip).Scan(&stats.TotalRequests)
+	query := "SELECT COUNT(*) FROM access_logs WHERE " + where.String()
+	err := db.DB.QueryRow(query, where.params()...).Scan(&stats.TotalRequests)
 	if err != nil {
 		return stats, err
 	}
 
-	query = "SELECT COUNT(DISTINCT url) FROM access_logs WHERE remote_addr = ? AND " + timeCondition
-	err = db.DB.QueryRow(query, ip).Scan(&stats.UniqueRoutes)
+	query = "SELECT COUNT(DISTINCT url) FROM access_logs WHERE " + where.String()
+	err = db.DB.QueryRow(query, where.params()...).Scan(&stats.UniqueRoutes)
 	if err != nil {
 		return stats, err
 	}
 
-	query = "SELECT COUNT(*) FROM access_logs WHERE remote_addr = ? AND " + timeCondition + " AND status_code >= 400"
-	err = db.DB.QueryRow(query, ip).Scan(&stats.ErrorCount)
+	failed := where.and("status_code >= 400")
+	query = "SELECT COUNT(*) FROM access_logs WHERE " + failed.String()
+	err = db.DB.QueryRow(query, failed.params()...).Scan(&stats.ErrorCount)
 	if err != nil {
 		return stats, err
 	}
 
-	query = "SELECT AVG(response_time) FROM access_logs WHERE remote_addr = ? AND " + timeCondition + " AND response_time > 0"
+	query = "SELECT AVG(" + durationMs + ") FROM access_logs WHERE " + where.String()
 	var avgTime sql.NullFloat64
-	err = db.DB.QueryRow(query, ip).Scan(&avgTime)
+	err = db.DB.QueryRow(query, where.params()...).Scan(&avgTime)
 	if err != nil {
 		return stats, err
 	}
@@ -476,9 +652,10 @@ func getIPStats(ip, timeCondition string) (IPStats, error) {
 		stats.AvgResponseTime = avgTime.Float64
 	}
 
+	// First and last seen cover all time, not just the range
 	query = "SELECT MIN(timestamp), MAX(timestamp) FROM access_logs WHERE remote_addr = ?"
 	var firstSeen, lastSeen sql.NullString
-	err = db.DB.QueryRow(query, ip).Scan(&firstSeen, &lastSeen)
+	err = db.DB.QueryRow(query, filter.IP).Scan(&firstSeen, &lastSeen)
 	if err != nil {
 		return stats, err
 	}
@@ -492,16 +669,17 @@ func getIPStats(ip, timeCondition string) (IPStats, error) {
 	return stats, nil
 }
 
-func getIPTopRoutes(ip, timeCondition string) ([]RouteCount, error) {
+func getIPTopRoutes(filter Filter) ([]RouteCount, error) {
+	where := filter.where()
 	query := `
 		SELECT url, COUNT(*) as count
 		FROM access_logs
-		WHERE remote_addr = ? AND ` + timeCondition + `
+		WHERE ` + where.String() + `
 		GROUP BY url
 		ORDER BY count DESC
 		LIMIT 50`
 
-	rows, err := db.DB.Query(query, ip)
+	rows, err := db.DB.Query(query, where.params()...)
 	if err != nil {
 		return nil, err
 	}
@@ -520,15 +698,16 @@ func getIPTopRoutes(ip, timeCondition string) ([]RouteCount, error) {
 	return results, nil
 }
 
-func getIPStatusCodes(ip, timeCondition string) ([]StatusCount, error) {
+func getIPStatusCodes(filter Filter) ([]StatusCount, error) {
+	where := filter.where()
 	query := `
 		SELECT status_code, COUNT(*) as count
 		FROM access_logs
-		WHERE remote_addr = ? AND ` + timeCondition + `
+		WHERE ` + where.String() + `
 		GROUP BY status_code
 		ORDER BY count DESC`
 
-	rows, err := db.DB.Query(query, ip)
+	rows, err := db.DB.Query(query, where.params()...)
 	if err != nil {
 		return nil, err
 	}
@@ -547,15 +726,16 @@ func getIPStatusCodes(ip, timeCondition string) ([]StatusCount, error) {
 	return results, nil
 }
 
-func getIPHourlyActivity(ip, timeCondition string) ([]HourCount, error) {
+func getIPHourlyActivity(filter Filter) ([]HourCount, error) {
+	where := filter.where()
 	query := `
 		SELECT strftime('%H', timestamp) as hour, COUNT(*) as count
 		FROM access_logs
-		WHERE remote_addr = ? AND ` + timeCondition + `
+		WHERE ` + where.String() + `
 		GROUP BY hour
 		ORDER BY hour`
 
-	rows, err := db.DB.Query(query, ip)
+	rows, err := db.DB.Query(query, where.params()...)
 	if err != nil {
 		return nil, err
 	}
@@ -582,18 +762,19 @@ func getIPHourlyActivity(ip, timeCondition string) ([]HourCount, error) {
 	return results, nil
 }
 
-func getIPUserAgents(ip, timeCondition string) ([]UACount, error) {
+func getIPUserAgents(filter Filter) ([]UACount, error) {
+	where := filter.where()
 	query := `
 		SELECT
 			COALESCE(user_agent, 'Unknown') as user_agent,
 			COUNT(*) as count
 		FROM access_logs
-		WHERE remote_addr = ? AND ` + timeCondition + `
+		WHERE ` + where.String() + `
 		GROUP BY user_agent
 		ORDER BY count DESC
 		LIMIT 10`
 
-	rows, err := db.DB.Query(query, ip)
+	rows, err := db.DB.Query(query, where.params()...)
 	if err != nil {
 		return nil, err
 	}
@@ -612,16 +793,17 @@ func getIPUserAgents(ip, timeCondition string) ([]UACount, error) {
 	return results, nil
 }
 
-func getIPAccessLogs(ip, timeCondition string) ([]AccessLog, error) {
+func getIPAccessLogs(filter Filter) ([]AccessLog, error) {
+	where := filter.where()
 	query := `
-		SELECT timestamp, method, url, status_code, response_time,
+		SELECT timestamp, method, url, status_code, COALESCE(response_time, 0),
 			   request_size, response_size, COALESCE(user_agent, 'Unknown') as user_agent
 		FROM access_logs
-		WHERE remote_addr = ? AND ` + timeCondition + `
+		WHERE ` + where.String() + `
 		ORDER BY timestamp DESC
 		LIMIT 100`
 
-	rows, err := db.DB.Query(query, ip)
+	rows, err := db.DB.Query(query, where.params()...)
 	if err != nil {
 		return nil, err
 	}
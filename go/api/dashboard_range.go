@@ -0,0 +1,172 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"server/db"
+	"time"
+)
+
+const (
+	sqlTimeFormat = "2006-01-02 15:04:05"
+	autoBuckets   = 120  // Roughly how many points an automatic bucket size aims for
+	maxBuckets    = 2000 // Explicit bucket sizes that would produce more are rejected
+)
+
+var dashboardPeriods = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+var bucketSizes = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+	"1d":  24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// Bucket sizes in the order automatic selection tries them
+var bucketOrder = []string{"1m", "5m", "15m", "1h", "6h", "1d", "7d"}
+
+type TimeRange struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Bucket string    `json:"bucket"`
+}
+
+type SeriesPoint struct {
+	Time            time.Time `json:"time"`
+	Requests        int       `json:"requests"`
+	Errors          int       `json:"errors"`
+	AvgResponseTime float64   `json:"avgResponseTime"`
+}
+
+// parseTimeRange reads either from/to (RFC 3339 or YYYY-MM-DD, to defaulting to now)
+// or a named period ending now, plus an optional bucket size.
+func parseTimeRange(r *http.Request, defaultPeriod string) (TimeRange, error) {
+	query := r.URL.Query()
+	now := time.Now().UTC()
+	timeRange := TimeRange{To: now}
+
+	if query.Get("from") != "" || query.Get("to") != "" {
+		if query.Get("from") == "" {
+			return timeRange, errors.New("from is required with to")
+		}
+		from, err := parseRangeTime(query.Get("from"))
+		if err != nil {
+			return timeRange, errors.New("invalid from time")
+		}
+		timeRange.From = from
+		if query.Get("to") != "" {
+			if timeRange.To, err = parseRangeTime(query.Get("to")); err != nil {
+				return timeRange, errors.New("invalid to time")
+			}
+		}
+		if !timeRange.From.Before(timeRange.To) {
+			return timeRange, errors.New("from must be before to")
+		}
+	} else {
+		period := query.Get("period")
+		if period == "" {
+			period = defaultPeriod
+		}
+		duration, ok := dashboardPeriods[period]
+		if !ok {
+			return timeRange, errors.New("period must be 1h, 24h, 7d or 30d")
+		}
+		timeRange.From = now.Add(-duration)
+	}
+
+	timeRange.Bucket = query.Get("bucket")
+	if timeRange.Bucket == "" || timeRange.Bucket == "auto" {
+		timeRange.Bucket = bucketOrder[len(bucketOrder)-1]
+		for _, name := range bucketOrder {
+			if timeRange.To.Sub(timeRange.From)/bucketSizes[name] <= autoBuckets {
+				timeRange.Bucket = name
+				break
+			}
+		}
+	}
+	size, ok := bucketSizes[timeRange.Bucket]
+	if !ok {
+		return timeRange, errors.New("bucket must be auto, 1m, 5m, 15m, 1h, 6h, 1d or 7d")
+	}
+	if timeRange.To.Sub(timeRange.From)/size > maxBuckets {
+		return timeRange, errors.New("bucket is too small for this range")
+	}
+
+	return timeRange, nil
+}
+
+func parseRangeTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// previous is the range of the same length immediately before this one.
+func (t TimeRange) previous() TimeRange {
+	length := t.To.Sub(t.From)
+	return TimeRange{From: t.From.Add(-length), To: t.From, Bucket: t.Bucket}
+}
+
+// getSeries returns request, error and latency totals per bucket, including empty
+// buckets. time.Truncate aligns buckets to Go's zero time, which is midnight UTC
+// on a Monday, so days start at midnight UTC and weeks on Monday.
+func getSeries(timeRange TimeRange, filter Filter) ([]SeriesPoint, error) {
+	size := bucketSizes[timeRange.Bucket]
+	start := timeRange.From.Truncate(size)
+
+	var series []SeriesPoint
+	for t := start; t.Before(timeRange.To); t = t.Add(size) {
+		series = append(series, SeriesPoint{Time: t})
+	}
+
+	where := filter.where()
+	rows, err := db.DB.Query(`
+		SELECT
+			(CAST(strftime('%s', timestamp) AS INTEGER) - ?) / ? as bucket,
+			COUNT(*),
+			SUM(CASE WHEN status_code >= 400 THEN 1 ELSE 0 END),
+			AVG(`+durationMs+`)
+		FROM access_logs
+		WHERE `+where.String()+`
+		GROUP BY bucket`, append([]any{start.Unix(), int64(size.Seconds())}, where.params()...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket int
+		var point SeriesPoint
+		var avg sql.NullFloat64 // NULL when the bucket only holds streams
+		if err := rows.Scan(&bucket, &point.Requests, &point.Errors, &avg); err != nil {
+			return nil, err
+		}
+		point.AvgResponseTime = avg.Float64
+		if bucket < 0 || bucket >= len(series) {
+			continue
+		}
+		point.Time = series[bucket].Time
+		series[bucket] = point
+	}
+
+	return series, rows.Err()
+}
+
+// percentChange is nil when there was nothing to compare against.
+func percentChange(previous, current float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := (current - previous) / previous * 100
+	return &change
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"server/db"
+	"server/logs"
+	"strconv"
+	"strings"
+)
+
+type DevLogs struct {
+	Range      TimeRange     `json:"range"`
+	Entries    []DevLogEntry `json:"entries,omitempty"`
+	Groups     []DevLogGroup `json:"groups,omitempty"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+type DevLogEntry struct {
+	ID        int64           `json:"id"`
+	Timestamp string          `json:"timestamp"`
+	Level     string          `json:"level"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Entries with the same level and message. Data usually differs between them, so
+// the group carries the most recent entry's.
+type DevLogGroup struct {
+	Level     string          `json:"level"`
+	Message   string          `json:"message"`
+	Count     int             `json:"count"`
+	FirstSeen string          `json:"firstSeen"`
+	LastSeen  string          `json:"lastSeen"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// DevLogsHandler lists dev_logs newest first, or grouped with group=true. Filters
+// are level, q (a message substring) and the dashboard's period or from/to.
+// Listing pages backwards with cursor, the id to continue before.
+func DevLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logs.HTTPError(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	timeRange, err := parseTimeRange(r, "24h")
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	where := Filter{From: timeRange.From, To: timeRange.To}.where()
+	if level := strings.ToUpper(query.Get("level")); level != "" {
+		where = where.and("level = ?", level)
+	}
+	if search := query.Get("q"); search != "" {
+		where = where.and(`message LIKE ? ESCAPE '\'`, "%"+escapeLike(search)+"%")
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	data := DevLogs{Range: timeRange}
+	if query.Get("group") == "true" {
+		data.Groups, err = getDevLogGroups(where, limit)
+	} else {
+		if cursor := query.Get("cursor"); cursor != "" {
+			before, err := strconv.ParseInt(cursor, 10, 64)
+			if err != nil {
+				logs.HTTPError(w, r, err, http.StatusBadRequest, "Invalid cursor")
+				return
+			}
+			where = where.and("id < ?", before)
+		}
+		data.Entries, err = getDevLogEntries(where, limit)
+		if len(data.Entries) == limit {
+			data.NextCursor = strconv.FormatInt(data.Entries[limit-1].ID, 10)
+		}
+	}
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get dev logs")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(data)
+}
+
+func DevLogsPageHandler(w http.ResponseWriter, r *http.Request) {
+	ServeTemplate(w, r, "dev_logs.html", nil)
+}
+
+func getDevLogEntries(where sqlWhere, limit int) ([]DevLogEntry, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, timestamp, level, message, COALESCE(data, '')
+		FROM dev_logs
+		WHERE `+where.String()+`
+		ORDER BY id DESC
+		LIMIT ?`, where.params(limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []DevLogEntry
+	for rows.Next() {
+		var entry DevLogEntry
+		var data string
+		if err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.Level, &entry.Message, &data); err != nil {
+			return nil, err
+		}
+		entry.Data = devLogData(data)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func getDevLogGroups(where sqlWhere, limit int) ([]DevLogGroup, error) {
+	rows, err := db.DB.Query(`
+		SELECT grouped.level, grouped.message, grouped.count,
+			strftime('%Y-%m-%dT%H:%M:%SZ', grouped.first_seen), strftime('%Y-%m-%dT%H:%M:%SZ', grouped.last_seen),
+			COALESCE(latest.data, '')
+		FROM (
+			SELECT level, message, COUNT(*) as count, MIN(timestamp) as first_seen,
+				MAX(timestamp) as last_seen, MAX(id) as latest_id
+			FROM dev_logs
+			WHERE `+where.String()+`
+			GROUP BY level, message
+		) grouped
+		JOIN dev_logs latest ON latest.id = grouped.latest_id
+		ORDER BY grouped.count DESC, grouped.last_seen DESC
+		LIMIT ?`, where.params(limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []DevLogGroup
+	for rows.Next() {
+		var group DevLogGroup
+		var data string
+		err := rows.Scan(&group.Level, &group.Message, &group.Count, &group.FirstSeen, &group.LastSeen, &data)
+		if err != nil {
+			return nil, err
+		}
+		group.Data = devLogData(data)
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
+
+// devLogData passes valid JSON through as-is and quotes anything else as a string.
+func devLogData(data string) json.RawMessage {
+	if data == "" || data == "null" {
+		return nil
+	}
+	if json.Valid([]byte(data)) {
+		return json.RawMessage(data)
+	}
+	quoted, _ := json.Marshal(data)
+	return quoted
+}
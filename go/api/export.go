@@ -0,0 +1,288 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"server/db"
+	"server/logs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportDefaultLimit = 10000
+	exportMaxLimit     = 100000
+)
+
+var exportColumns = map[string][]string{
+	"access_logs": {
+		"id", "timestamp", "method", "url", "status_code", "response_time", "duration_us", "remote_addr",
+		"request_size", "response_size", "user_agent", "referrer", "ua_browser", "ua_version", "ua_os",
+		"ua_device", "ua_crawler", "country", "asn", "as_org",
+	},
+	"dev_logs": {"id", "timestamp", "level", "message", "data"},
+}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"clf":    "text/plain; charset=utf-8",
+}
+
+// ExportQuery selects one page of log rows. Pages are keyed on id: After is the
+// last id of the previous page, so rows logged during an export never shift pages.
+type ExportQuery struct {
+	Filter
+	Table  string
+	Format string
+	Level  string // dev_logs only
+	After  int64
+	Limit  int
+}
+
+func ParseExportQuery(values url.Values) (ExportQuery, error) {
+	filter, err := parseFilter(values)
+	if err != nil {
+		return ExportQuery{}, err
+	}
+	query := ExportQuery{
+		Filter: filter,
+		Table:  values.Get("table"),
+		Format: values.Get("format"),
+		Level:  strings.ToUpper(values.Get("level")),
+		Limit:  exportDefaultLimit,
+	}
+	if query.Table == "" {
+		query.Table = "access_logs"
+	}
+	if query.Format == "" {
+		query.Format = "ndjson"
+	}
+
+	if _, ok := exportColumns[query.Table]; !ok {
+		return query, errors.New("table must be access_logs or dev_logs")
+	}
+	if _, ok := exportContentTypes[query.Format]; !ok {
+		return query, errors.New("format must be csv, ndjson or clf")
+	}
+	if query.Table == "dev_logs" {
+		if query.Format == "clf" {
+			return query, errors.New("clf is only available for access_logs")
+		}
+		if query.UAClass != "" {
+			return query, errors.New("class is only available for access_logs")
+		}
+	}
+
+	if value := values.Get("from"); value != "" {
+		if query.From, err = parseRangeTime(value); err != nil {
+			return query, errors.New("invalid from time")
+		}
+	}
+	if value := values.Get("to"); value != "" {
+		if query.To, err = parseRangeTime(value); err != nil {
+			return query, errors.New("invalid to time")
+		}
+	}
+	if value := values.Get("cursor"); value != "" {
+		if query.After, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return query, errors.New("invalid cursor")
+		}
+	}
+	if value := values.Get("limit"); value != "" {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil || query.Limit <= 0 || query.Limit > exportMaxLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", exportMaxLimit)
+		}
+	}
+	return query, nil
+}
+
+// where adds the page's cursor and level to the filter.
+func (q ExportQuery) where() sqlWhere {
+	columns := accessLogColumns
+	if q.Table == "dev_logs" {
+		columns = devLogColumns
+	}
+	where := q.Filter.whereIn(columns).and("id > ?", q.After)
+	if q.Level != "" {
+		where = where.and("level = ?", q.Level)
+	}
+	return where
+}
+
+// nextCursor finds the cursor for the page after this one, if there is one. It's
+// worked out before streaming so it can go in the response headers.
+func (q ExportQuery) nextCursor() (int64, bool, error) {
+	where := q.where()
+	var last int64
+	err := db.DB.QueryRow("SELECT id FROM "+q.Table+" WHERE "+where.String()+" ORDER BY id LIMIT 1 OFFSET ?",
+		where.params(q.Limit-1)...).Scan(&last)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	next := q
+	next.After = last
+	where = next.where()
+	var more bool
+	err = db.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM "+q.Table+" WHERE "+where.String()+")", where.params()...).Scan(&more)
+	return last, more, err
+}
+
+// ExportLogs writes one page of rows and returns the id of the last row written,
+// which is the cursor for the next page.
+func ExportLogs(w io.Writer, q ExportQuery, header bool) (int64, int, error) {
+	columns := exportColumns[q.Table]
+	where := q.where()
+	rows, err := db.DB.Query("SELECT "+strings.Join(columns, ", ")+" FROM "+q.Table+" WHERE "+where.String()+" ORDER BY id LIMIT ?",
+		where.params(q.Limit)...)
+	if err != nil {
+		return q.After, 0, err
+	}
+	defer rows.Close()
+
+	var csvWriter *csv.Writer
+	if q.Format == "csv" {
+		csvWriter = csv.NewWriter(w)
+		defer csvWriter.Flush()
+		if header {
+			csvWriter.Write(columns)
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	last, count := q.After, 0
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return last, count, err
+		}
+		record := make(map[string]any, len(columns))
+		for i, column := range columns {
+			record[column] = exportValue(values[i])
+		}
+
+		switch q.Format {
+		case "csv":
+			fields := make([]string, len(columns))
+			for i, column := range columns {
+				if record[column] != nil {
+					fields[i] = fmt.Sprint(record[column])
+				}
+			}
+			err = csvWriter.Write(fields)
+		case "ndjson":
+			err = encoder.Encode(record)
+		case "clf":
+			_, err = io.WriteString(w, combinedLogLine(record)+"\n")
+		}
+		if err != nil {
+			return last, count, err
+		}
+
+		last = record["id"].(int64)
+		count++
+	}
+
+	return last, count, rows.Err()
+}
+
+func exportValue(value any) any {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return value
+}
+
+// combinedLogLine formats an access log row in the Combined Log Format. Stored
+// referrers have no scheme, so one is put back to keep log analyzers happy.
+func combinedLogLine(record map[string]any) string {
+	field := func(name string) string {
+		if value, ok := record[name].(string); ok && value != "" {
+			return value
+		}
+		return "-"
+	}
+	quoted := func(value string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+
+	host := field("remote_addr")
+	host, _, _ = strings.Cut(host, ",")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	timestamp, _ := time.Parse(time.RFC3339Nano, field("timestamp"))
+	referrer := field("referrer")
+	if referrer != "-" {
+		referrer = "https://" + referrer
+	}
+	size := "-"
+	if n, ok := record["response_size"].(int64); ok && n > 0 {
+		size = strconv.FormatInt(n, 10)
+	}
+
+	return fmt.Sprintf("%s - - [%s] %s %v %s %s %s",
+		host, timestamp.Format("02/Jan/2006:15:04:05 -0700"),
+		quoted(field("method")+" "+field("url")+" HTTP/1.1"), record["status_code"], size,
+		quoted(referrer), quoted(field("user_agent")))
+}
+
+// ExportHandler streams log rows for the filters in the query string. When more
+// rows remain, the next page's cursor is in X-Next-Cursor and a Link header.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logs.HTTPError(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query, err := ParseExportQuery(r.URL.Query())
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	next, more, err := query.nextCursor()
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to export logs")
+		return
+	}
+	if more {
+		cursor := strconv.FormatInt(next, 10)
+		nextQuery := r.URL.Query()
+		nextQuery.Set("cursor", cursor)
+		w.Header().Set("X-Next-Cursor", cursor)
+		w.Header().Set("Link", "<"+r.URL.Path+"?"+nextQuery.Encode()+`>; rel="next"`)
+	}
+
+	extension := map[string]string{"csv": "csv", "ndjson": "ndjson", "clf": "log"}[query.Format]
+	w.Header().Set("Content-Type", exportContentTypes[query.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, query.Table, extension))
+
+	if _, _, err := ExportLogs(w, query, true); err != nil {
+		// Headers are gone by now, so all that's left is to note it
+		logs.ERROR("Log export failed", map[string]any{"error": err.Error(), "table": query.Table})
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"server/logs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User agent classes a Filter accepts: "human" is everything not classified as a
+// bot, the rest match ua_device
+var uaClasses = map[string]bool{"human": true, "bot": true, "desktop": true, "mobile": true, "tablet": true}
+
+// Filter selects access_logs rows for the dashboard, IP analytics and exports, and
+// dev_logs rows for exports.
+// Zero values don't filter.
+type Filter struct {
+	From        time.Time
+	To          time.Time
+	IP          string
+	RoutePrefix string
+	Status      string // An exact code like 404, or a class like 5xx
+	Method      string
+	UAClass     string // human, bot, desktop, mobile or tablet
+	UserAgent   string // Substring match on the raw user agent
+}
+
+// filterColumns are the SQL expressions a Filter matches in one table. A table
+// without user agent classes leaves Device empty.
+type filterColumns struct {
+	IP        string
+	URL       string
+	Status    string
+	Method    string
+	UserAgent string
+	Device    string
+}
+
+var accessLogColumns = filterColumns{
+	IP:        "remote_addr",
+	URL:       "url",
+	Status:    "status_code",
+	Method:    "method",
+	UserAgent: "user_agent",
+	Device:    "ua_device",
+}
+
+// dev_logs rows only know about a request through the data HTTPError logs with it
+var devLogColumns = filterColumns{
+	IP:        "json_extract(data, '$.RemoteAddr')",
+	URL:       "json_extract(data, '$.route')",
+	Status:    "json_extract(data, '$.status')",
+	Method:    "json_extract(data, '$.method')",
+	UserAgent: "json_extract(data, '$.UserAgent')",
+}
+
+// sqlWhere is a WHERE clause built one condition at a time, keeping every value
+// as a bound parameter. Conditions themselves are always fixed SQL of ours.
+type sqlWhere struct {
+	conditions []string
+	args       []any
+}
+
+// and returns the clause with another condition, leaving the receiver as it was.
+func (w sqlWhere) and(condition string, args ...any) sqlWhere {
+	return sqlWhere{
+		conditions: append(w.conditions[:len(w.conditions):len(w.conditions)], condition),
+		args:       append(w.args[:len(w.args):len(w.args)], args...),
+	}
+}
+
+func (w sqlWhere) String() string {
+	if len(w.conditions) == 0 {
+		return "1 = 1"
+	}
+	return strings.Join(w.conditions, " AND ")
+}
+
+// params returns the clause's arguments followed by any for the rest of the query.
+func (w sqlWhere) params(extra ...any) []any {
+	return append(w.args[:len(w.args):len(w.args)], extra...)
+}
+
+// parseFilter reads the filters other than time: ip, route, status, method, class
+// and ua. Callers set the time range, which each of them parses differently.
+func parseFilter(values url.Values) (Filter, error) {
+	filter := Filter{
+		IP:          values.Get("ip"),
+		RoutePrefix: values.Get("route"),
+		Status:      values.Get("status"),
+		Method:      strings.ToUpper(values.Get("method")),
+		UAClass:     strings.ToLower(values.Get("class")),
+		UserAgent:   values.Get("ua"),
+	}
+	if filter.IP != "" {
+		filter.IP = logs.StoredIP(filter.IP)
+	}
+	if filter.Status != "" {
+		if _, _, err := filter.statusRange(); err != nil {
+			return filter, err
+		}
+	}
+	if filter.Method != "" && strings.Trim(filter.Method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return filter, errors.New("method must be an HTTP method like GET")
+	}
+	if filter.UAClass != "" && !uaClasses[filter.UAClass] {
+		return filter, errors.New("class must be human, bot, desktop, mobile or tablet")
+	}
+	return filter, nil
+}
+
+func (f Filter) statusRange() (int, int, error) {
+	if class, found := strings.CutSuffix(strings.ToLower(f.Status), "xx"); found {
+		n, err := strconv.Atoi(class)
+		if err != nil || n < 1 || n > 5 {
+			return 0, 0, errors.New("status must be a code like 404 or a class like 5xx")
+		}
+		return n * 100, n*100 + 99, nil
+	}
+	code, err := strconv.Atoi(f.Status)
+	if err != nil {
+		return 0, 0, errors.New("status must be a code like 404 or a class like 5xx")
+	}
+	return code, code, nil
+}
+
+func (f Filter) where() sqlWhere {
+	return f.whereIn(accessLogColumns)
+}
+
+// whereIn builds the clause against another table's columns.
+func (f Filter) whereIn(columns filterColumns) sqlWhere {
+	var where sqlWhere
+	if !f.From.IsZero() {
+		where = where.and("timestamp >= ?", f.From.UTC().Format(sqlTimeFormat))
+	}
+	if !f.To.IsZero() {
+		where = where.and("timestamp < ?", f.To.UTC().Format(sqlTimeFormat))
+	}
+	if f.IP != "" {
+		where = where.and(columns.IP+" = ?", f.IP)
+	}
+	if f.RoutePrefix != "" {
+		where = where.and(columns.URL+` LIKE ? ESCAPE '\'`, escapeLike(f.RoutePrefix)+"%")
+	}
+	if f.Status != "" {
+		low, high, _ := f.statusRange()
+		where = where.and(columns.Status+" BETWEEN ? AND ?", low, high)
+	}
+	if f.Method != "" {
+		where = where.and(columns.Method+" = ?", f.Method)
+	}
+	switch {
+	case f.UAClass == "" || columns.Device == "":
+	case f.UAClass == "human":
+		where = where.and("COALESCE(" + columns.Device + ", '') != 'bot'")
+	default:
+		where = where.and(columns.Device+" = ?", f.UAClass)
+	}
+	if f.UserAgent != "" {
+		where = where.and(columns.UserAgent+` LIKE ? ESCAPE '\'`, "%"+escapeLike(f.UserAgent)+"%")
+	}
+	return where
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
@@ -1,14 +1,47 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"server/config"
+	"server/db"
+	"server/jobs"
+	"server/middleware"
 	"server/types"
+	"syscall"
+	"time"
 )
 
+// Readiness fails when a data directory has less than this much free space
+const minFreeBytes = 500 << 20
+
+// W_OK for access(2), which the syscall package doesn't name
+const accessWrite = 0x2
+
+// Templates every page depends on
+var requiredTemplates = []string{"index.html", "40X.html"}
+
+type HealthCheck struct {
+	Status   string  `json:"status"` // ok or fail
+	Message  string  `json:"message,omitempty"`
+	Duration float64 `json:"duration_ms"`
+	Details  any     `json:"details,omitempty"`
+}
+
+type Readiness struct {
+	Status string                 `json:"status"` // ok or degraded
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// HealthHandler is the liveness check: it only shows the process is serving
+// requests, so orchestrators don't restart it over a problem a restart won't fix.
 func HealthHandler(w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -18,3 +51,110 @@ func HealthHandler(w http.ResponseWriter, r *http.Request) {
 		Message: "OK",
 	})
 }
+
+// ReadinessHandler runs every dependency check and answers 503 if any failed.
+// Anyone can see which checks pass, but only admins see the messages and details,
+// which include job errors and free space.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return
+	}
+	detailed := middleware.IsAdmin(r)
+
+	readiness := Readiness{Status: "ok", Checks: make(map[string]HealthCheck)}
+	for name, check := range map[string]func(context.Context) (any, error){
+		"database":  checkDatabase,
+		"templates": checkTemplates,
+		"uploads":   checkUploadDir,
+		"jobs":      checkJobs,
+		"disk":      checkDiskSpace,
+	} {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		start := time.Now()
+		details, err := check(ctx)
+		cancel()
+
+		result := HealthCheck{Status: "ok", Details: details, Duration: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			result.Status = "fail"
+			result.Message = err.Error()
+			readiness.Status = "degraded"
+		}
+		if !detailed {
+			result.Message, result.Details = "", nil
+		}
+		readiness.Checks[name] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if readiness.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness)
+}
+
+func checkDatabase(ctx context.Context) (any, error) {
+	if db.DB == nil {
+		return nil, errors.New("database not initialized")
+	}
+	if err := db.DB.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var tables int
+	if err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		return nil, err
+	}
+	return map[string]int{"tables": tables}, nil
+}
+
+func checkTemplates(ctx context.Context) (any, error) {
+	details := map[string]int{"loaded": len(Templates)}
+	for _, name := range requiredTemplates {
+		if Templates[name] == nil {
+			return details, fmt.Errorf("template %s is not loaded", name)
+		}
+	}
+	return details, nil
+}
+
+func checkUploadDir(ctx context.Context) (any, error) {
+	info, err := os.Stat(config.UploadDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", config.UploadDir)
+	}
+	if err := syscall.Access(config.UploadDir, accessWrite); err != nil {
+		return nil, fmt.Errorf("%s is not writable: %w", config.UploadDir, err)
+	}
+	return nil, nil
+}
+
+// checkJobs only reports on the scheduled jobs. An overdue job doesn't make the
+// server unready, since taking it out of rotation won't get the job running.
+func checkJobs(ctx context.Context) (any, error) {
+	return jobs.Statuses(), nil
+}
+
+func checkDiskSpace(ctx context.Context) (any, error) {
+	details := make(map[string]uint64)
+	var low []string
+	for _, dir := range []string{"db", config.UploadDir, config.CacheDir} {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(dir, &stat); err != nil {
+			return details, fmt.Errorf("%s: %w", dir, err)
+		}
+		free := stat.Bavail * uint64(stat.Bsize)
+		details[dir] = free
+		if free < minFreeBytes {
+			low = append(low, dir)
+		}
+	}
+	if len(low) > 0 {
+		return details, fmt.Errorf("less than %d MB free in %v", minFreeBytes>>20, low)
+	}
+	return details, nil
+}
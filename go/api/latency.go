@@ -0,0 +1,134 @@
+package api
+
+import (
+	"database/sql"
+	"server/db"
+)
+
+// Request duration in milliseconds. Rows from before duration_us was recorded only
+// have whole milliseconds, which is the best they can offer.
+const durationMs = "COALESCE(duration_us / 1000.0, response_time)"
+
+// The request path without its query string
+const routePath = "CASE WHEN instr(url, '?') > 0 THEN substr(url, 1, instr(url, '?') - 1) ELSE url END"
+
+// Nearest-rank percentiles over rows ranked by duration within each route. Integer
+// math stands in for ceil(), which SQLite only has with its math functions enabled.
+const percentileColumns = `
+	MAX(CASE WHEN rank = (50 * total + 99) / 100 THEN duration END) as p50,
+	MAX(CASE WHEN rank = (90 * total + 99) / 100 THEN duration END) as p90,
+	MAX(CASE WHEN rank = (99 * total + 99) / 100 THEN duration END) as p99`
+
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+type RouteLatency struct {
+	URL   string `json:"url"`
+	Count int    `json:"count"`
+	Percentiles
+}
+
+type SlowRequest struct {
+	Timestamp  string  `json:"timestamp"`
+	Method     string  `json:"method"`
+	URL        string  `json:"url"`
+	StatusCode int     `json:"status_code"`
+	RemoteAddr string  `json:"remote_addr"`
+	Duration   float64 `json:"duration"` // Milliseconds
+}
+
+func getLatencyPercentiles(filter Filter) (Percentiles, error) {
+	var percentiles Percentiles
+	var p50, p90, p99 sql.NullFloat64
+
+	where := filter.where().and(durationMs + " IS NOT NULL")
+	err := db.DB.QueryRow(`
+		WITH ranked AS (
+			SELECT `+durationMs+` as duration,
+				ROW_NUMBER() OVER (ORDER BY `+durationMs+`) as rank,
+				COUNT(*) OVER () as total
+			FROM access_logs
+			WHERE `+where.String()+`
+		)
+		SELECT `+percentileColumns+` FROM ranked`, where.params()...).Scan(&p50, &p90, &p99)
+	if err != nil {
+		return percentiles, err
+	}
+
+	percentiles.P50, percentiles.P90, percentiles.P99 = p50.Float64, p90.Float64, p99.Float64
+	return percentiles, nil
+}
+
+// getRouteLatencies reports percentiles for the busiest routes, ignoring the query
+// string. Routes with fewer than minRequests are left out as too noisy to rank.
+func getRouteLatencies(filter Filter, minRequests, limit int) ([]RouteLatency, error) {
+	where := filter.where().and(durationMs + " IS NOT NULL")
+	query := `
+		WITH ranked AS (
+			SELECT route, duration,
+				ROW_NUMBER() OVER (PARTITION BY route ORDER BY duration) as rank,
+				COUNT(*) OVER (PARTITION BY route) as total
+			FROM (
+				SELECT ` + routePath + ` as route, ` + durationMs + ` as duration
+				FROM access_logs
+				WHERE ` + where.String() + `
+			)
+		)
+		SELECT route, MAX(total) as count, ` + percentileColumns + `
+		FROM ranked
+		GROUP BY route
+		HAVING count >= ?
+		ORDER BY p90 DESC
+		LIMIT ?`
+
+	rows, err := db.DB.Query(query, where.params(minRequests, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []RouteLatency
+	for rows.Next() {
+		var route RouteLatency
+		var p50, p90, p99 sql.NullFloat64
+		if err := rows.Scan(&route.URL, &route.Count, &p50, &p90, &p99); err != nil {
+			return nil, err
+		}
+		route.P50, route.P90, route.P99 = p50.Float64, p90.Float64, p99.Float64
+		results = append(results, route)
+	}
+
+	return results, rows.Err()
+}
+
+func getSlowRequests(filter Filter, limit int) ([]SlowRequest, error) {
+	where := filter.where().and(durationMs + " IS NOT NULL")
+	query := `
+		SELECT timestamp, method, url, status_code, remote_addr, ` + durationMs + ` as duration
+		FROM access_logs
+		WHERE ` + where.String() + `
+		ORDER BY duration DESC
+		LIMIT ?`
+
+	rows, err := db.DB.Query(query, where.params(limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SlowRequest
+	for rows.Next() {
+		var request SlowRequest
+		err := rows.Scan(&request.Timestamp, &request.Method, &request.URL, &request.StatusCode,
+			&request.RemoteAddr, &request.Duration)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, request)
+	}
+
+	return results, rows.Err()
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"server/logs"
+	"time"
+)
+
+const liveStatsInterval = 5 * time.Second
+
+type LiveStats struct {
+	Window          int     `json:"window"` // Seconds covered by the counts
+	Requests        int     `json:"requests"`
+	Errors          int     `json:"errors"`
+	AvgResponseTime float64 `json:"avgResponseTime"`
+	Subscribers     int     `json:"subscribers"`
+}
+
+// LiveDashboardHandler streams access entries as Server-Sent Events, with a stats
+// event summarizing the entries seen every liveStatsInterval. When the broker drops
+// the stream for falling behind, a dropped event is sent and the stream ends, and
+// EventSource reconnects on its own.
+func LiveDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logs.HTTPError(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logs.HTTPError(w, r, errors.New("response writer does not support flushing"), http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	entries, unsubscribe := logs.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(liveStatsInterval)
+	defer ticker.Stop()
+
+	var stats LiveStats
+	var totalTime, timed int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case entry, ok := <-entries:
+			if !ok {
+				fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			stats.Requests++
+			if entry.StatusCode >= 400 {
+				stats.Errors++
+			}
+			if !entry.Streaming {
+				totalTime += entry.DurationUS
+				timed++
+			}
+			if err := writeEvent(w, "access", entry); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-ticker.C:
+			stats.Window = int(liveStatsInterval.Seconds())
+			stats.Subscribers = logs.Subscribers()
+			if timed > 0 {
+				stats.AvgResponseTime = float64(totalTime) / float64(timed) / 1000
+			}
+			if err := writeEvent(w, "stats", stats); err != nil {
+				return
+			}
+			flusher.Flush()
+			stats, totalTime, timed = LiveStats{}, 0, 0
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"math"
+	"server/types"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+const wordsPerMinute = 230
+
+func newMarkdown() goldmark.Markdown {
+	return goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Footnote,
+			highlighting.NewHighlighting(
+				highlighting.WithStyle("solarized-light"),
+				highlighting.WithFormatOptions(
+					chromahtml.WithLineNumbers(true),
+				),
+			),
+			blogImageExtension{},
+			postFeaturesExtension{},
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			html.WithHardWraps(),
+			html.WithXHTML(),
+		),
+	)
+}
+
+type documentOutline struct {
+	toc         []types.TOCEntry
+	words       int
+	description string
+	image       string
+}
+
+// outlineDocument collects the headings, prose word count, first paragraph and first
+// image of a parsed post, and appends a self-link anchor to every heading that has an ID.
+func outlineDocument(doc ast.Node, source []byte) documentOutline {
+	var result documentOutline
+	var headings []types.TOCEntry
+
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := node.(type) {
+		case *ast.Text:
+			result.words += len(strings.Fields(string(n.Segment.Value(source))))
+		case *ast.Paragraph:
+			if result.description == "" {
+				result.description = nodeText(n, source)
+			}
+		case *ast.Image:
+			if result.image == "" {
+				result.image = string(n.Destination)
+				if name, ok := blogAssetName(result.image); ok {
+					result.image = blogAssetURL + name
+				}
+			}
+		case *ast.Heading:
+			id, ok := n.AttributeString("id")
+			if !ok {
+				return ast.WalkContinue, nil
+			}
+			idString := string(id.([]byte))
+
+			headings = append(headings, types.TOCEntry{
+				ID:    idString,
+				Title: nodeText(n, source),
+				Level: n.Level,
+			})
+
+			anchor := ast.NewLink()
+			anchor.Destination = []byte("#" + idString)
+			anchor.SetAttributeString("class", []byte("heading-anchor"))
+			anchor.SetAttributeString("title", []byte("Link to this section"))
+			anchor.AppendChild(anchor, ast.NewString([]byte("#")))
+			n.AppendChild(n, anchor)
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	result.toc = nestHeadings(headings)
+	return result
+}
+
+// nestHeadings turns a flat list of headings into a tree, where each heading owns
+// the deeper headings that follow it.
+func nestHeadings(flat []types.TOCEntry) []types.TOCEntry {
+	var nested []types.TOCEntry
+	for i := 0; i < len(flat); {
+		entry := flat[i]
+		end := i + 1
+		for end < len(flat) && flat[end].Level > entry.Level {
+			end++
+		}
+		entry.Children = nestHeadings(flat[i+1 : end])
+		nested = append(nested, entry)
+		i = end
+	}
+	return nested
+}
+
+func nodeText(node ast.Node, source []byte) string {
+	var text strings.Builder
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch t := n.(type) {
+		case *ast.Text:
+			text.Write(t.Segment.Value(source))
+			if t.SoftLineBreak() || t.HardLineBreak() {
+				text.WriteByte(' ')
+			}
+		case *ast.String:
+			text.Write(t.Value)
+		case *ast.Image:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(text.String())
+}
+
+func readingTime(words int) int {
+	return max(1, int(math.Ceil(float64(words)/wordsPerMinute)))
+}
@@ -0,0 +1,598 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"server/config"
+	"server/logs"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Posts opt into these with flags on their date line, e.g. "## 2025-10-20 math diagrams callouts".
+// "math:client" and "diagrams:client" skip server rendering and leave it to the browser.
+type postFeatures struct {
+	math           bool
+	serverMath     bool
+	diagrams       bool
+	serverDiagrams bool
+	callouts       bool
+
+	// Filled in while parsing, for the template to pull in stylesheets and scripts
+	usedMath      bool
+	clientMath    bool
+	clientMermaid bool
+}
+
+var postFeaturesKey = parser.NewContextKey()
+
+func (f *postFeatures) setFlag(flag string) bool {
+	name, mode, _ := strings.Cut(flag, ":")
+	if mode != "" && mode != "client" {
+		return false
+	}
+
+	switch name {
+	case "math":
+		f.math, f.serverMath = true, mode == ""
+	case "diagrams":
+		f.diagrams, f.serverDiagrams = true, mode == ""
+	case "callouts":
+		if mode != "" {
+			return false
+		}
+		f.callouts = true
+	default:
+		return false
+	}
+	return true
+}
+
+func contextFeatures(pc parser.Context) *postFeatures {
+	features, _ := pc.Get(postFeaturesKey).(*postFeatures)
+	return features
+}
+
+var (
+	kindMath      = ast.NewNodeKind("Math")
+	kindMathBlock = ast.NewNodeKind("MathBlock")
+	kindCallout   = ast.NewNodeKind("Callout")
+	kindDiagram   = ast.NewNodeKind("Diagram")
+
+	calloutMarker = regexp.MustCompile(`^\[!(NOTE|TIP|IMPORTANT|WARNING|CAUTION)\][ \t]*(.*)$`)
+)
+
+type mathNode struct {
+	ast.BaseInline
+	tex     string
+	display bool
+	html    string
+}
+
+func (n *mathNode) Kind() ast.NodeKind { return kindMath }
+
+func (n *mathNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.tex}, nil)
+}
+
+type mathBlockNode struct {
+	ast.BaseBlock
+	tex  strings.Builder
+	html string
+}
+
+func (n *mathBlockNode) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlockNode) IsRaw() bool { return true }
+
+func (n *mathBlockNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.tex.String()}, nil)
+}
+
+type calloutNode struct {
+	ast.BaseBlock
+	kind  string
+	title string
+}
+
+func (n *calloutNode) Kind() ast.NodeKind { return kindCallout }
+
+func (n *calloutNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Kind": n.kind, "Title": n.title}, nil)
+}
+
+type diagramNode struct {
+	ast.BaseBlock
+	lang   string
+	source string
+	svg    string
+}
+
+func (n *diagramNode) Kind() ast.NodeKind { return kindDiagram }
+
+func (n *diagramNode) IsRaw() bool { return true }
+
+func (n *diagramNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Lang": n.lang}, nil)
+}
+
+// mathInlineParser reads $...$ and $$...$$ within a line. Like pandoc, an opening $
+// must not be followed by a space and a closing $ must not be preceded by one or
+// followed by a digit, so prices like "$5 and $10" stay text.
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	if features := contextFeatures(pc); features == nil || !features.math {
+		return nil
+	}
+
+	line, _ := block.PeekLine()
+	delimiter := 1
+	if len(line) > 1 && line[1] == '$' {
+		delimiter = 2
+	}
+	body := line[delimiter:]
+
+	end := -1
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\\' {
+			i++
+			continue
+		}
+		if body[i] != '$' {
+			continue
+		}
+		if delimiter == 1 || (i+1 < len(body) && body[i+1] == '$') {
+			end = i
+			break
+		}
+	}
+	if end <= 0 {
+		return nil
+	}
+	if delimiter == 1 && (isSpace(body[0]) || isSpace(body[end-1]) || (end+1 < len(body) && body[end+1] >= '0' && body[end+1] <= '9')) {
+		return nil
+	}
+
+	block.Advance(delimiter + end + delimiter)
+	return &mathNode{tex: string(body[:end]), display: delimiter == 2}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// mathBlockParser reads display math fenced by lines starting and ending with $$.
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	if features := contextFeatures(pc); features == nil || !features.math {
+		return nil, parser.NoChildren
+	}
+
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	rest, found := bytes.CutPrefix(bytes.TrimSpace(line[pos:]), []byte("$$"))
+	if !found {
+		return nil, parser.NoChildren
+	}
+
+	node := &mathBlockNode{}
+	reader.Advance(segment.Len() - 1)
+	if tex, closed := bytes.CutSuffix(rest, []byte("$$")); closed {
+		node.tex.Write(tex)
+		return node, parser.Close
+	}
+	if len(rest) > 0 {
+		node.tex.Write(rest)
+		node.tex.WriteByte('\n')
+	}
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+	block := node.(*mathBlockNode)
+	reader.Advance(segment.Len() - 1)
+
+	trimmed := bytes.TrimSpace(line)
+	if tex, closed := bytes.CutSuffix(trimmed, []byte("$$")); closed {
+		block.tex.Write(tex)
+		return parser.Close
+	}
+	block.tex.Write(trimmed)
+	block.tex.WriteByte('\n')
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool { return true }
+
+func (mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+// postFeaturesTransformer turns GitHub-style "> [!NOTE]" blockquotes into callouts,
+// mermaid and dot code fences into diagrams, and pre-renders math and diagrams.
+type postFeaturesTransformer struct{}
+
+func (postFeaturesTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	features := contextFeatures(pc)
+	if features == nil {
+		return
+	}
+	source := reader.Source()
+
+	var maths []ast.Node
+	var quotes []*ast.Blockquote
+	var fences []*ast.FencedCodeBlock
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *mathNode, *mathBlockNode:
+			maths = append(maths, n)
+		case *ast.Blockquote:
+			quotes = append(quotes, n)
+		case *ast.FencedCodeBlock:
+			fences = append(fences, n)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, node := range maths {
+		features.usedMath = true
+		switch n := node.(type) {
+		case *mathNode:
+			n.html = features.renderMath(n.tex, n.display, false)
+		case *mathBlockNode:
+			n.html = features.renderMath(n.tex.String(), true, true)
+		}
+	}
+
+	if features.callouts {
+		for _, quote := range quotes {
+			convertCallout(quote, source)
+		}
+	}
+
+	if features.diagrams {
+		for _, fence := range fences {
+			convertDiagram(fence, source, features)
+		}
+	}
+}
+
+func (f *postFeatures) renderMath(tex string, display, block bool) string {
+	if f.serverMath {
+		tool := katexInline
+		if display {
+			tool = katexDisplay
+		}
+		if rendered, ok := renderWithTool(tool, tex); ok {
+			return rendered
+		}
+	}
+
+	f.clientMath = true
+	switch {
+	case block:
+		return `<div class="math math-display">\[` + html.EscapeString(tex) + `\]</div>`
+	case display:
+		return `<span class="math math-display">\[` + html.EscapeString(tex) + `\]</span>`
+	default:
+		return `<span class="math math-inline">\(` + html.EscapeString(tex) + `\)</span>`
+	}
+}
+
+func convertCallout(quote *ast.Blockquote, source []byte) {
+	paragraph, ok := quote.FirstChild().(*ast.Paragraph)
+	if !ok || paragraph.Lines().Len() == 0 {
+		return
+	}
+	first := paragraph.Lines().At(0)
+	match := calloutMarker.FindSubmatch(bytes.TrimRight(first.Value(source), "\r\n"))
+	if match == nil {
+		return
+	}
+
+	callout := &calloutNode{kind: strings.ToLower(string(match[1])), title: string(match[2])}
+	if callout.title == "" {
+		callout.title = string(match[1][:1]) + strings.ToLower(string(match[1][1:]))
+	}
+
+	// Drop the inlines that came from the marker line
+	for child := paragraph.FirstChild(); child != nil; {
+		next := child.NextSibling()
+		textNode, ok := child.(*ast.Text)
+		if !ok || textNode.Segment.Start >= first.Stop {
+			break
+		}
+		paragraph.RemoveChild(paragraph, child)
+		child = next
+	}
+	if paragraph.ChildCount() == 0 {
+		quote.RemoveChild(quote, paragraph)
+	}
+
+	for child := quote.FirstChild(); child != nil; {
+		next := child.NextSibling()
+		callout.AppendChild(callout, child)
+		child = next
+	}
+	quote.Parent().ReplaceChild(quote.Parent(), quote, callout)
+}
+
+func convertDiagram(fence *ast.FencedCodeBlock, source []byte, features *postFeatures) {
+	lang := string(fence.Language(source))
+	if lang == "graphviz" {
+		lang = "dot"
+	}
+	if lang != "mermaid" && lang != "dot" {
+		return
+	}
+
+	var body strings.Builder
+	lines := fence.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		body.Write(line.Value(source))
+	}
+	diagram := &diagramNode{lang: lang, source: body.String()}
+
+	// Mermaid is always drawn in the browser; mermaid-cli needs a headless Chromium
+	if features.serverDiagrams && lang == "dot" {
+		if svg, ok := renderWithTool(graphviz, diagram.source); ok {
+			if start := strings.Index(svg, "<svg"); start >= 0 {
+				diagram.svg = svg[start:]
+			}
+		}
+	}
+
+	// Browsers can draw mermaid themselves; dot without Graphviz stays a code block
+	if diagram.svg == "" {
+		if lang != "mermaid" {
+			return
+		}
+		features.clientMermaid = true
+	}
+	fence.Parent().ReplaceChild(fence.Parent(), fence, diagram)
+}
+
+type postFeaturesRenderer struct{}
+
+func (postFeaturesRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, renderMathNode)
+	reg.Register(kindMathBlock, renderMathNode)
+	reg.Register(kindCallout, renderCallout)
+	reg.Register(kindDiagram, renderDiagram)
+}
+
+func renderMathNode(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	switch n := node.(type) {
+	case *mathNode:
+		w.WriteString(n.html)
+	case *mathBlockNode:
+		w.WriteString(n.html)
+		w.WriteByte('\n')
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func renderCallout(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*calloutNode)
+	if entering {
+		fmt.Fprintf(w, "<div class=\"callout callout-%s\">\n<p class=\"callout-title\">%s</p>\n", n.kind, html.EscapeString(n.title))
+	} else {
+		w.WriteString("</div>\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+func renderDiagram(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*diagramNode)
+	if n.svg != "" {
+		fmt.Fprintf(w, "<figure class=\"diagram diagram-%s\">%s</figure>\n", n.lang, n.svg)
+	} else {
+		fmt.Fprintf(w, "<pre class=\"mermaid\">%s</pre>\n", html.EscapeString(n.source))
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+type postFeaturesExtension struct{}
+
+func (postFeaturesExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 150)),
+		parser.WithASTTransformers(util.Prioritized(postFeaturesTransformer{}, 100)),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(postFeaturesRenderer{}, 100),
+	))
+}
+
+// Pre-rendering shells out to KaTeX and Graphviz as installed by the Dockerfile, with
+// KaTeX pinned to the version of the stylesheet. Nothing is fetched at runtime; when
+// a tool is missing, posts fall back to client-side rendering. Posts are parsed on
+// every request, so tools never run inline: the first request queues the work and
+// falls back to the client, and later requests pick up the cached output.
+type markdownTool struct {
+	name    string
+	command string
+	args    []string // Input goes to stdin and output is read from stdout
+	timeout time.Duration
+}
+
+var (
+	katexInline  = markdownTool{"katex", "katex", nil, 30 * time.Second}
+	katexDisplay = markdownTool{"katex", "katex", []string{"--display-mode"}, 30 * time.Second}
+	graphviz     = markdownTool{"graphviz", "dot", []string{"-Tsvg"}, 30 * time.Second}
+
+	// Output that means the input was bad rather than the tool being unavailable
+	toolInputError = regexp.MustCompile(`(?i)parse ?error|syntax error|lexical error`)
+)
+
+const (
+	markdownToolBackoff = 10 * time.Minute
+	markdownToolEntries = 1000 // In-memory results and failures; results are also cached on disk
+)
+
+type markdownToolJob struct {
+	tool  markdownTool
+	input string
+	key   string
+}
+
+var markdownTools = struct {
+	sync.Mutex
+	once    sync.Once
+	queue   chan markdownToolJob
+	results map[string]string
+	failed  map[string]bool
+	pending map[string]bool
+	down    map[string]time.Time // By tool name, so one entry per markdownTool
+}{
+	queue:   make(chan markdownToolJob, 256),
+	results: make(map[string]string),
+	failed:  make(map[string]bool),
+	pending: make(map[string]bool),
+	down:    make(map[string]time.Time),
+}
+
+// renderWithTool returns the tool's output for input if it has been rendered before,
+// and otherwise queues it for the background worker.
+func renderWithTool(tool markdownTool, input string) (string, bool) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s", tool.command, strings.Join(tool.args, "\x00"), input)
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	markdownTools.Lock()
+	defer markdownTools.Unlock()
+
+	if output, ok := markdownTools.results[key]; ok {
+		return output, true
+	}
+	if markdownTools.failed[key] || markdownTools.pending[key] || time.Now().Before(markdownTools.down[tool.name]) {
+		return "", false
+	}
+
+	if output, err := os.ReadFile(markdownToolCachePath(key)); err == nil {
+		rememberToolResult(markdownTools.results, key, string(output))
+		return string(output), true
+	}
+
+	markdownTools.once.Do(func() { go runMarkdownTools() })
+	select {
+	case markdownTools.queue <- markdownToolJob{tool: tool, input: input, key: key}:
+		markdownTools.pending[key] = true
+	default:
+	}
+	return "", false
+}
+
+func markdownToolCachePath(key string) string {
+	return filepath.Join(config.CacheDir, "markdown", key+".html")
+}
+
+func runMarkdownTools() {
+	for job := range markdownTools.queue {
+		markdownTools.Lock()
+		down := time.Now().Before(markdownTools.down[job.tool.name])
+		markdownTools.Unlock()
+
+		var output string
+		var err error
+		if !down {
+			output, err = runMarkdownTool(job.tool, job.input)
+		}
+
+		markdownTools.Lock()
+		delete(markdownTools.pending, job.key)
+		switch {
+		case down:
+		case err == nil:
+			rememberToolResult(markdownTools.results, job.key, output)
+		case toolInputError.MatchString(err.Error()):
+			rememberToolResult(markdownTools.failed, job.key, true)
+			logs.WARN("Failed to pre-render blog markup", map[string]any{"tool": job.tool.name, "error": err.Error()})
+		default:
+			markdownTools.down[job.tool.name] = time.Now().Add(markdownToolBackoff)
+			logs.WARN("Markdown renderer unavailable, falling back to client-side rendering", map[string]any{"tool": job.tool.name, "error": err.Error()})
+		}
+		markdownTools.Unlock()
+
+		if err == nil && !down {
+			path := markdownToolCachePath(job.key)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+				os.WriteFile(path, []byte(output), 0644)
+			}
+		}
+	}
+}
+
+// rememberToolResult stores a result, starting the map over once it is full. The
+// disk cache keeps results across that, so it only costs a file read per entry.
+func rememberToolResult[V any](results map[string]V, key string, value V) {
+	if len(results) >= markdownToolEntries {
+		clear(results)
+	}
+	results[key] = value
+}
+
+func runMarkdownTool(tool markdownTool, input string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tool.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, tool.command, tool.args...)
+	cmd.Stdin = strings.NewReader(input)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s timed out after %s", tool.name, tool.timeout)
+		}
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	output := strings.TrimSpace(stdout.String())
+	if output == "" {
+		return "", errors.New(tool.name + " produced no output")
+	}
+	return output, nil
+}
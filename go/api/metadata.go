@@ -1,9 +1,27 @@
 package api
 
 import (
+	"encoding/xml"
 	"net/http"
+	"os"
+	"server/config"
+	"server/logs"
+	"time"
 )
 
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
 func FaviconHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "public, max-age=86400")
 	filePath := r.URL.Path[len("/favicon.ico"):]
@@ -14,8 +32,38 @@ func RobotsHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "static/meta/robots.txt")
 }
 
+// SitemapHandler serves the hand-written sitemap with every published blog post appended.
 func SitemapHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "static/meta/sitemap.xml")
+	content, err := os.ReadFile("static/meta/sitemap.xml")
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to read sitemap")
+		return
+	}
+
+	var sitemap sitemapURLSet
+	if err := xml.Unmarshal(content, &sitemap); err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to parse sitemap")
+		return
+	}
+
+	posts, err := loadBlogPosts()
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to load blog posts")
+		return
+	}
+
+	for _, post := range publishedPosts(posts, time.Now()) {
+		sitemap.URLs = append(sitemap.URLs, sitemapURL{
+			Loc:        config.BaseURL + "/blog/" + post.Slug,
+			LastMod:    post.Date.Format("2006-01-02"),
+			ChangeFreq: "yearly",
+			Priority:   "0.5",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(sitemap)
 }
 
 func SecurityTxtHandler(w http.ResponseWriter, r *http.Request) {
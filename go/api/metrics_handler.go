@@ -0,0 +1,33 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"server/config"
+	"server/metrics"
+	"strings"
+)
+
+// MetricsHandler serves metrics on the public listener to scrapers holding the
+// metrics token. Without a token configured it 404s, and metrics are only served on
+// the separate metrics listener, if that is configured.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if config.MetricsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(config.MetricsToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ServeMetrics(w, r)
+}
+
+func ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Write(w)
+}
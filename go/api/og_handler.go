@@ -0,0 +1,351 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"server/config"
+	"server/db"
+	"server/imaging"
+	"server/logs"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	ogWidth   = 1200
+	ogHeight  = 630
+	ogMargin  = 70
+	ogThumbPx = 360
+)
+
+var (
+	ogInk    = color.RGBA{0x11, 0x11, 0x11, 0xff}
+	ogMuted  = color.RGBA{0x66, 0x66, 0x66, 0xff}
+	ogPaper  = color.RGBA{0xfd, 0xfc, 0xf8, 0xff}
+	ogAccent = color.RGBA{0x00, 0x00, 0xff, 0xff}
+
+	ogFonts struct {
+		once    sync.Once
+		regular *opentype.Font
+		bold    *opentype.Font
+		err     error
+	}
+
+	// Serializes rendering so concurrent crawlers don't render the same card twice
+	ogRenderMu sync.Mutex
+
+	// The fingerprint and extension that follow kind-id- in a cached card's name
+	ogCacheName = regexp.MustCompile(`^[0-9a-f]{16}\.png$`)
+)
+
+type ogCard struct {
+	Kicker    string
+	Title     string
+	Subtitle  string
+	Footer    string
+	ImagePath string
+}
+
+// OGImageHandler serves /og/blog/{slug}.png and /og/thread/{id}.png preview cards.
+// Cards are cached on disk under a fingerprint of their source, so editing a post
+// or a thread's OP produces a fresh card on the next request.
+func OGImageHandler(w http.ResponseWriter, r *http.Request) {
+	kind, file, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/og/"), "/")
+	id, isPNG := strings.CutSuffix(file, ".png")
+	if !found || !isPNG || id == "" || strings.ContainsAny(id, `/\.`) {
+		FourHundredHandler(w, r, 404)
+		return
+	}
+
+	var card ogCard
+	var err error
+	switch kind {
+	case "blog":
+		card, err = blogCard(id)
+	case "thread":
+		card, err = threadCard(id)
+	default:
+		FourHundredHandler(w, r, 404)
+		return
+	}
+	if err != nil {
+		FourHundredHandler(w, r, 404)
+		return
+	}
+
+	cached, err := cachedCard(kind, id, card)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to render preview image")
+		return
+	}
+	defer cached.Close()
+
+	info, err := cached.Stat()
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to read preview image")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), cached)
+}
+
+func blogCard(slug string) (ogCard, error) {
+	post, err := loadBlogPost(slug)
+	if err != nil {
+		return ogCard{}, err
+	}
+	if !isPublished(post, time.Now()) {
+		return ogCard{}, fmt.Errorf("blog post %s is not published", slug)
+	}
+
+	return ogCard{
+		Kicker:   siteName + " / blog",
+		Title:    post.Title,
+		Subtitle: post.Date.Format("January 2, 2006"),
+		Footer:   fmt.Sprintf("%d min read", post.ReadingTime),
+	}, nil
+}
+
+func threadCard(threadID string) (ogCard, error) {
+	if _, err := strconv.Atoi(threadID); err != nil {
+		return ogCard{}, err
+	}
+
+	var title, poster, contents string
+	var date time.Time
+	var imagePath sql.NullString
+	err := db.DB.QueryRow(`
+		SELECT title, poster, contents, date, image_path
+		FROM posts
+		WHERE thread = ? AND thread_owner = 1
+	`, threadID).Scan(&title, &poster, &contents, &date, &imagePath)
+	if err != nil {
+		return ogCard{}, err
+	}
+
+	if title == "" {
+		title = truncateWords(strings.Join(strings.Fields(contents), " "), 90)
+	}
+	if title == "" {
+		title = "Thread " + threadID
+	}
+
+	card := ogCard{
+		Kicker:   "Petrarchive / No." + threadID,
+		Title:    title,
+		Subtitle: poster,
+		Footer:   ArchivePost{Date: date}.EST().Format("2006-01-02 15:04"),
+	}
+	if imagePath.Valid && imagePath.String != "" {
+		card.ImagePath = imagePath.String
+	}
+	return card, nil
+}
+
+// cachedCard opens the rendered card, rendering it if the cache holds no card for
+// the current source fingerprint. The file is opened under the same lock that
+// replaces stale cards, so a concurrent re-render can't remove it first.
+func cachedCard(kind, id string, card ogCard) (*os.File, error) {
+	prefix := kind + "-" + id + "-"
+	fingerprint := cardFingerprint(card)
+	dir := filepath.Join(config.CacheDir, "og")
+	cachePath := filepath.Join(dir, prefix+fingerprint+".png")
+
+	ogRenderMu.Lock()
+	defer ogRenderMu.Unlock()
+
+	if cached, err := os.Open(cachePath); err == nil {
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	img, err := renderCard(card)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dir, "render-*.png")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := png.Encode(tmp, img); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	// Only this card's old fingerprints; other ids can share the prefix, e.g. foo and foo-bar
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if rest, found := strings.CutPrefix(entry.Name(), prefix); found && ogCacheName.MatchString(rest) {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		return nil, err
+	}
+	return os.Open(cachePath)
+}
+
+func cardFingerprint(card ogCard) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "v1\x00%s\x00%s\x00%s\x00%s\x00%s", card.Kicker, card.Title, card.Subtitle, card.Footer, card.ImagePath)
+
+	if card.ImagePath != "" {
+		info, err := os.Stat(card.ImagePath)
+		if err == nil {
+			fmt.Fprintf(hash, "\x00%d", info.ModTime().UnixNano())
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func renderCard(card ogCard) (image.Image, error) {
+	ogFonts.once.Do(func() {
+		ogFonts.regular, ogFonts.err = opentype.Parse(goregular.TTF)
+		if ogFonts.err == nil {
+			ogFonts.bold, ogFonts.err = opentype.Parse(gobold.TTF)
+		}
+	})
+	if ogFonts.err != nil {
+		return nil, ogFonts.err
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(ogPaper), image.Point{}, draw.Src)
+	draw.Draw(canvas, image.Rect(0, 0, ogWidth, 12), image.NewUniform(ogAccent), image.Point{}, draw.Src)
+
+	textLeft := ogMargin
+	if card.ImagePath != "" {
+		if thumb, err := loadCardThumbnail(card.ImagePath); err == nil {
+			bounds := thumb.Bounds()
+			top := (ogHeight - bounds.Dy()) / 2
+			draw.Draw(canvas, image.Rect(ogMargin, top, ogMargin+bounds.Dx(), top+bounds.Dy()), thumb, bounds.Min, draw.Src)
+			textLeft = ogMargin + ogThumbPx + 50
+		} else {
+			logs.WARN("Failed to load thread image for preview card", map[string]any{"path": card.ImagePath, "error": err.Error()})
+		}
+	}
+	textWidth := ogWidth - ogMargin - textLeft
+
+	kickerFace, err := opentype.NewFace(ogFonts.regular, &opentype.FaceOptions{Size: 30, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer kickerFace.Close()
+
+	titleSize := 68.0
+	if card.ImagePath != "" {
+		titleSize = 56
+	}
+	titleFace, err := opentype.NewFace(ogFonts.bold, &opentype.FaceOptions{Size: titleSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer titleFace.Close()
+
+	drawText(canvas, kickerFace, ogMuted, textLeft, ogMargin+30, card.Kicker)
+
+	lineHeight := int(titleSize * 1.2)
+	lines := wrapText(titleFace, card.Title, textWidth, 4)
+	y := ogMargin + 60 + lineHeight
+	for _, line := range lines {
+		drawText(canvas, titleFace, ogInk, textLeft, y, line)
+		y += lineHeight
+	}
+
+	drawText(canvas, kickerFace, ogInk, textLeft, y+20, card.Subtitle)
+	drawText(canvas, kickerFace, ogMuted, textLeft, ogHeight-ogMargin, card.Footer)
+
+	return canvas, nil
+}
+
+func loadCardThumbnail(path string) (image.Image, error) {
+	img, _, err := imaging.Decode(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return imaging.Thumbnail(img, ogThumbPx, ogThumbPx), nil
+}
+
+func drawText(dst draw.Image, face font.Face, c color.Color, x, y int, text string) {
+	if text == "" {
+		return
+	}
+	drawer := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
+
+// wrapText breaks text into lines no wider than width, ellipsizing the last line
+// when it runs past maxLines.
+func wrapText(face font.Face, text string, width, maxLines int) []string {
+	limit := fixed.I(width)
+	var lines []string
+	var line string
+
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if font.MeasureString(face, candidate) <= limit || line == "" {
+			line = candidate
+			continue
+		}
+		lines = append(lines, line)
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := lines[maxLines-1]
+		for font.MeasureString(face, last+"…") > limit {
+			cut := strings.LastIndex(last, " ")
+			if cut <= 0 {
+				break
+			}
+			last = last[:cut]
+		}
+		lines[maxLines-1] = last + "…"
+	}
+
+	return lines
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"server/config"
+	"server/types"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const siteName = "cnqso.com"
+
+func blogPostMeta(post types.BlogPost) types.PageMeta {
+	url := config.BaseURL + "/blog/" + post.Slug
+	// Drafts and scheduled posts have no card yet; /og/ only renders published posts
+	var card string
+	if isPublished(post, time.Now()) {
+		card = config.BaseURL + "/og/blog/" + post.Slug + ".png"
+	}
+	image := absoluteURL(post.Image)
+	if image == "" {
+		image = card
+	}
+
+	posting := map[string]any{
+		"@context":         "https://schema.org",
+		"@type":            "BlogPosting",
+		"headline":         post.Title,
+		"description":      post.Description,
+		"datePublished":    post.Date.Format(time.RFC3339),
+		"url":              url,
+		"mainEntityOfPage": url,
+		"wordCount":        post.WordCount,
+		"author":           map[string]any{"@type": "Person", "name": "cnqso", "url": config.BaseURL},
+	}
+	if image != "" {
+		posting["image"] = image
+	}
+
+	return types.PageMeta{
+		Title:       post.Title,
+		Description: post.Description,
+		URL:         url,
+		Image:       card,
+		Type:        "article",
+		Published:   post.Date,
+		JSONLD:      posting,
+	}
+}
+
+func threadMeta(threadID, threadTitle string, posts []ArchivePost) types.PageMeta {
+	op := posts[0]
+	for _, post := range posts {
+		if post.IsOP {
+			op = post
+			break
+		}
+	}
+
+	title := threadTitle
+	if title == "" {
+		title = "Thread " + threadID
+	}
+	url := config.BaseURL + "/petrarchive/thread/" + threadID
+	description := truncateWords(strings.Join(strings.Fields(op.Contents), " "), 200)
+	card := config.BaseURL + "/og/thread/" + threadID + ".png"
+	image := absoluteURL(op.ImageURL)
+	if image == "" {
+		image = card
+	}
+
+	posting := map[string]any{
+		"@context":      "https://schema.org",
+		"@type":         "DiscussionForumPosting",
+		"headline":      title,
+		"text":          op.Contents,
+		"datePublished": op.Date.Format(time.RFC3339),
+		"url":           url,
+		"author":        map[string]any{"@type": "Person", "name": op.Poster},
+		"interactionStatistic": map[string]any{
+			"@type":                "InteractionCounter",
+			"interactionType":      "https://schema.org/CommentAction",
+			"userInteractionCount": len(posts) - 1,
+		},
+	}
+	if image != "" {
+		posting["image"] = image
+	}
+
+	return types.PageMeta{
+		Title:       title + " - Petrarchive",
+		Description: description,
+		URL:         url,
+		Image:       card,
+		Type:        "article",
+		Published:   op.Date,
+		JSONLD:      posting,
+	}
+}
+
+// MetaTags renders a page's description, canonical link, Open Graph and Twitter card
+// tags, and its JSON-LD block. Templates call it as {{metaTags .Meta}}.
+func MetaTags(meta types.PageMeta) template.HTML {
+	var b strings.Builder
+
+	tag := func(attr, key, value string) {
+		if value == "" {
+			return
+		}
+		fmt.Fprintf(&b, "<meta %s=\"%s\" content=\"%s\" />\n", attr, key, html.EscapeString(value))
+	}
+
+	tag("name", "description", meta.Description)
+	if meta.URL != "" {
+		fmt.Fprintf(&b, "<link rel=\"canonical\" href=\"%s\" />\n", html.EscapeString(meta.URL))
+	}
+
+	tag("property", "og:site_name", siteName)
+	tag("property", "og:type", meta.Type)
+	tag("property", "og:title", meta.Title)
+	tag("property", "og:description", meta.Description)
+	tag("property", "og:url", meta.URL)
+	tag("property", "og:image", meta.Image)
+	if !meta.Published.IsZero() {
+		tag("property", "article:published_time", meta.Published.Format(time.RFC3339))
+	}
+
+	card := "summary"
+	if meta.Image != "" {
+		card = "summary_large_image"
+	}
+	tag("name", "twitter:card", card)
+	tag("name", "twitter:title", meta.Title)
+	tag("name", "twitter:description", meta.Description)
+	tag("name", "twitter:image", meta.Image)
+
+	if meta.JSONLD != nil {
+		// json.Marshal escapes <, > and &, so the payload can't close the script tag
+		if jsonLD, err := json.Marshal(meta.JSONLD); err == nil {
+			fmt.Fprintf(&b, "<script type=\"application/ld+json\">%s</script>\n", jsonLD)
+		}
+	}
+
+	return template.HTML(b.String())
+}
+
+func absoluteURL(path string) string {
+	if path == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return config.BaseURL + "/" + strings.TrimPrefix(path, "/")
+}
+
+func truncateWords(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+
+	cut := strings.LastIndex(text[:limit], " ")
+	if cut <= 0 {
+		cut = limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return strings.TrimRight(text[:cut], ",.;:") + "…"
+}
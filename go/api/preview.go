@@ -0,0 +1,53 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"server/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreviewURL returns a signed link that shows a draft or scheduled post until ttl elapses.
+func PreviewURL(slug string, ttl time.Duration) (string, error) {
+	if config.PreviewSecret == "" {
+		return "", fmt.Errorf("CNQSO_PREVIEW_SECRET is not set")
+	}
+
+	if _, err := loadBlogPost(slug); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	token := expires + "." + signPreview(slug, expires)
+
+	return config.BaseURL + "/blog/" + slug + "?preview=" + url.QueryEscape(token), nil
+}
+
+func validPreviewToken(slug, token string, now time.Time) bool {
+	if config.PreviewSecret == "" || token == "" {
+		return false
+	}
+
+	expires, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(signPreview(slug, expires)))
+}
+
+func signPreview(slug, expires string) string {
+	mac := hmac.New(sha256.New, []byte(config.PreviewSecret))
+	mac.Write([]byte(slug + "|" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
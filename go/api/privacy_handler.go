@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"server/logs"
+	"strings"
+)
+
+// PurgeIPHandler deletes everything stored about one address, for visitor data
+// deletion requests. It takes {"ip": "203.0.113.7"} and reports rows per table.
+func PurgeIPHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		logs.HTTPError(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var request struct {
+		IP string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	ip := strings.TrimSpace(request.IP)
+	if ip == "" || ip == logs.UntrackedAddr {
+		logs.HTTPError(w, r, errors.New("missing ip"), http.StatusBadRequest, "An IP address is required")
+		return
+	}
+
+	purged, err := logs.PurgeIP(ip)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to purge IP")
+		return
+	}
+
+	// The address itself is left out of the log, as that is what was just removed
+	logs.INFO("Purged stored data for an IP", map[string]any{"rows": purged})
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "purged": purged})
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"server/db"
 	"server/logs"
+	"server/types"
 	"strconv"
 	"strings"
 	"time"
@@ -268,9 +269,11 @@ func ThreadHandler(w http.ResponseWriter, r *http.Request, threadID string) {
 		ThreadID    string
 		ThreadTitle string
 		Posts       []ArchivePost
+		Meta        types.PageMeta
 	}{
 		ThreadID:    threadID,
 		ThreadTitle: threadTitle,
 		Posts:       posts,
+		Meta:        threadMeta(threadID, threadTitle, posts),
 	})
 }
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"server/logs"
+	"server/search"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const searchLimit = 50
+
+var blogIndex struct {
+	sync.Mutex
+	signature   string
+	nextPublish time.Time
+}
+
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	var results []search.Result
+	var searchErr string
+	if query != "" {
+		var err error
+		results, err = runSearch(query)
+		if err != nil {
+			logs.WARN("Search failed", map[string]any{"query": query, "error": err.Error()})
+			searchErr = "Search is unavailable right now."
+		}
+	}
+
+	ServeTemplate(w, r, "search.html", struct {
+		Query   string
+		Results []search.Result
+		Error   string
+	}{
+		Query:   query,
+		Results: results,
+		Error:   searchErr,
+	})
+}
+
+func SearchAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		logs.HTTPError(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		logs.HTTPError(w, r, errors.New("missing query"), http.StatusBadRequest, "q parameter is required")
+		return
+	}
+
+	results, err := runSearch(query)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusServiceUnavailable, "Search is unavailable")
+		return
+	}
+	if results == nil {
+		results = []search.Result{}
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Query   string          `json:"query"`
+		Results []search.Result `json:"results"`
+	}{
+		Query:   query,
+		Results: results,
+	})
+}
+
+func runSearch(query string) ([]search.Result, error) {
+	return search.Query(query, searchLimit)
+}
+
+// SyncBlogIndex reindexes blog posts whose files changed and drops posts that were
+// removed or unpublished. It runs at startup and then every minute, and only
+// reparses the posts when a file's modification time changes or a scheduled post
+// comes due, so most runs are a stat of each file.
+func SyncBlogIndex() error {
+	if !search.Enabled {
+		return nil
+	}
+
+	blogIndex.Lock()
+	defer blogIndex.Unlock()
+
+	files, err := filepath.Glob(filepath.Join("blog-posts", "*.md"))
+	if err != nil {
+		return err
+	}
+
+	var signature strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		signature.WriteString(file + "@" + strconv.FormatInt(info.ModTime().UnixNano(), 10) + ";")
+	}
+
+	now := time.Now()
+	if signature.String() == blogIndex.signature && (blogIndex.nextPublish.IsZero() || now.Before(blogIndex.nextPublish)) {
+		return nil
+	}
+
+	posts, err := loadBlogPosts()
+	if err != nil {
+		return err
+	}
+
+	indexed, err := search.BlogVersions()
+	if err != nil {
+		return err
+	}
+
+	var nextPublish time.Time
+	for _, post := range posts {
+		if !isPublished(post, now) {
+			if !post.Draft && (nextPublish.IsZero() || post.Date.Before(nextPublish)) {
+				nextPublish = post.Date
+			}
+			continue
+		}
+
+		info, err := os.Stat(post.FilePath)
+		if err != nil {
+			return err
+		}
+		version := strconv.FormatInt(info.ModTime().UnixNano(), 10)
+
+		if indexed[post.Slug] != version {
+			err := search.IndexBlogPost(post.Slug, post.Title, search.PlainText(string(post.Content)), version)
+			if err != nil {
+				return err
+			}
+		}
+		delete(indexed, post.Slug)
+	}
+
+	for slug := range indexed {
+		if err := search.RemoveBlogPost(slug); err != nil {
+			return err
+		}
+	}
+
+	blogIndex.signature = signature.String()
+	blogIndex.nextPublish = nextPublish
+	return nil
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"path"
+	"server/db"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sessionGap       = 30 * time.Minute // Inactivity that ends a session
+	sessionMaxRows   = 5000             // Requests read per IP, newest first
+	sessionMaxPaths  = 50               // Path sequence kept per session
+	sessionsReturned = 50
+)
+
+// Paths that only vulnerability scanners ask a site like this one for
+var scannerProbes = []string{
+	"/wp-", "/wordpress", "/xmlrpc.php", "/.env", "/.git", "/.aws", "/.ssh", "/phpmyadmin", "/pma",
+	"/cgi-bin", "/admin.php", "/config.", "/vendor/phpunit", "/actuator", "/boaform", "/.well-known/security",
+	"/server-status", "/owa/", "/autodiscover", "/hnap1", "/solr", "/shell", "/eval-stdin",
+}
+
+type Session struct {
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"`
+	Duration    float64     `json:"duration"` // Seconds
+	UserAgent   string      `json:"userAgent"`
+	Kind        string      `json:"kind"` // human, crawler or scanner
+	Requests    int         `json:"requests"`
+	EntryPage   string      `json:"entryPage"`
+	Paths       []string    `json:"paths"` // In order, repeats of the previous path left out
+	StatusCodes map[int]int `json:"statusCodes"`
+
+	crawler  bool // Classified as a bot from the user agent
+	probes   int
+	notFound int
+	other    int // Methods browsers and crawlers don't send
+}
+
+// getSessions splits the filter's requests into sessions per user agent, ending one
+// after sessionGap without a request. Newest sessions come first.
+func getSessions(filter Filter) ([]Session, error) {
+	where := filter.where()
+	rows, err := db.DB.Query(`
+		SELECT timestamp, method, url, status_code, COALESCE(user_agent, ''),
+			COALESCE(ua_device, '') = 'bot' OR COALESCE(ua_crawler, '') != ''
+		FROM (
+			SELECT * FROM access_logs
+			WHERE `+where.String()+`
+			ORDER BY timestamp DESC
+			LIMIT ?
+		)
+		ORDER BY timestamp ASC`, where.params(sessionMaxRows)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	open := make(map[string]*Session)
+	for rows.Next() {
+		var timestamp time.Time
+		var method, rawURL, userAgent string
+		var status int
+		var bot bool
+		if err := rows.Scan(&timestamp, &method, &rawURL, &status, &userAgent, &bot); err != nil {
+			return nil, err
+		}
+
+		session := open[userAgent]
+		if session == nil || timestamp.Sub(session.End) > sessionGap {
+			session = &Session{
+				Start:       timestamp,
+				UserAgent:   userAgent,
+				EntryPage:   rawURL,
+				StatusCodes: make(map[int]int),
+			}
+			open[userAgent] = session
+			sessions = append(sessions, session)
+		}
+
+		session.End = timestamp
+		session.Requests++
+		session.StatusCodes[status]++
+		session.crawler = session.crawler || bot
+		if status == 404 {
+			session.notFound++
+		}
+		if method != "GET" && method != "HEAD" && method != "POST" && method != "OPTIONS" {
+			session.other++
+		}
+
+		routePath, _, _ := strings.Cut(rawURL, "?")
+		if isScannerProbe(routePath) {
+			session.probes++
+		}
+		if len(session.Paths) < sessionMaxPaths && (len(session.Paths) == 0 || session.Paths[len(session.Paths)-1] != routePath) {
+			session.Paths = append(session.Paths, routePath)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := []Session{}
+	for _, session := range sessions {
+		session.Duration = session.End.Sub(session.Start).Seconds()
+		session.Kind = session.classify()
+		results = append(results, *session)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Start.After(results[j].Start)
+	})
+	if len(results) > sessionsReturned {
+		results = results[:sessionsReturned]
+	}
+	return results, nil
+}
+
+// classify calls a session a scanner when it probes for software we don't run or
+// mostly misses, whatever its user agent claims. Otherwise declared bots and
+// sessions that start at robots.txt are crawlers, and everything else is human.
+func (s *Session) classify() string {
+	if s.probes > 0 || s.other > 0 || (s.Requests >= 5 && s.notFound*2 >= s.Requests) {
+		return "scanner"
+	}
+	if s.crawler || strings.HasPrefix(s.EntryPage, "/robots.txt") {
+		return "crawler"
+	}
+	return "human"
+}
+
+func isScannerProbe(routePath string) bool {
+	lower := strings.ToLower(routePath)
+	for _, probe := range scannerProbes {
+		if strings.HasPrefix(lower, probe) {
+			return true
+		}
+	}
+	switch path.Ext(lower) {
+	case ".php", ".asp", ".aspx", ".jsp", ".cgi", ".sql", ".bak":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/url"
+	"server/config"
+	"server/db"
+	"server/logs"
+	"sort"
+	"strings"
+)
+
+type TrafficSources struct {
+	Domains       []DomainCount `json:"domains"`
+	SearchEngines []DomainCount `json:"searchEngines"`
+	InternalFlows []FlowCount   `json:"internalFlows"`
+}
+
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int    `json:"count"`
+}
+
+type FlowCount struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int    `json:"count"`
+}
+
+// getTrafficSources groups the referrers of page views into external domains, search
+// engines and navigation between our own pages. Asset requests are left out so a
+// page's stylesheets and scripts don't count as flows.
+func getTrafficSources(filter Filter, limit int) (TrafficSources, error) {
+	sources := TrafficSources{}
+
+	siteHost := ""
+	if u, err := url.Parse(config.BaseURL); err == nil {
+		siteHost = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	}
+
+	where := filter.where().and("referrer != '' AND method = 'GET' AND status_code < 400")
+	rows, err := db.DB.Query(`
+		SELECT referrer, url, COUNT(*)
+		FROM access_logs
+		WHERE `+where.String()+`
+		GROUP BY referrer, url`, where.params()...)
+	if err != nil {
+		return sources, err
+	}
+	defer rows.Close()
+
+	domains := make(map[string]int)
+	engines := make(map[string]int)
+	flows := make(map[[2]string]int)
+	for rows.Next() {
+		var referrer, rawURL string
+		var count int
+		if err := rows.Scan(&referrer, &rawURL, &count); err != nil {
+			return sources, err
+		}
+		if _, _, ok := contentFor(rawURL); !ok {
+			continue
+		}
+
+		host := logs.ReferrerHost(referrer)
+		if host == siteHost {
+			_, from, _ := strings.Cut(referrer, "/")
+			from, _, _ = strings.Cut(from, "?")
+			to, _, _ := strings.Cut(rawURL, "?")
+			flows[[2]string{"/" + from, to}] += count
+			continue
+		}
+
+		domains[host] += count
+		if engine := logs.SearchEngine(host); engine != "" {
+			engines[engine] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return sources, err
+	}
+
+	sources.Domains = sortedDomainCounts(domains, limit)
+	sources.SearchEngines = sortedDomainCounts(engines, limit)
+	for flow, count := range flows {
+		sources.InternalFlows = append(sources.InternalFlows, FlowCount{From: flow[0], To: flow[1], Count: count})
+	}
+	sort.Slice(sources.InternalFlows, func(i, j int) bool {
+		a, b := sources.InternalFlows[i], sources.InternalFlows[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.From+a.To < b.From+b.To
+	})
+	if len(sources.InternalFlows) > limit {
+		sources.InternalFlows = sources.InternalFlows[:limit]
+	}
+
+	return sources, nil
+}
+
+func sortedDomainCounts(counts map[string]int, limit int) []DomainCount {
+	var results []DomainCount
+	for domain, count := range counts {
+		results = append(results, DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Count != results[j].Count {
+			return results[i].Count > results[j].Count
+		}
+		return results[i].Domain < results[j].Domain
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"server/config"
+	"server/db"
+	"server/jobs"
+	"server/logs"
+	"strings"
+	"time"
+)
+
+const uptimeHistory = 500 // Most recent checks returned per route
+
+type UptimeReport struct {
+	Range        TimeRange     `json:"range"`
+	Availability *float64      `json:"availability"` // Across every route
+	Routes       []RouteUptime `json:"routes"`
+}
+
+type RouteUptime struct {
+	Route           string        `json:"route"`
+	Checks          int           `json:"checks"`
+	Up              int           `json:"up"`
+	Availability    *float64      `json:"availability"` // Percent of checks that were up, null without checks
+	AvgLatency      float64       `json:"avgLatency"`   // Milliseconds
+	ChecksumChanges int           `json:"checksumChanges"`
+	Latest          *UptimeCheck  `json:"latest"`
+	History         []UptimeCheck `json:"history"` // Oldest first
+}
+
+type UptimeCheck struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode"`
+	Latency    float64   `json:"latency"`
+	Checksum   string    `json:"checksum,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Up         bool      `json:"up"`
+}
+
+// UptimeHandler reports the uptime job's checks per route over the dashboard's
+// period or from/to. Checks are up or down as jobs.UptimeUp decides.
+func UptimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logs.HTTPError(w, r, errors.New("method not allowed"), http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	timeRange, err := parseTimeRange(r, "24h")
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := getUptimeReport(timeRange)
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to get uptime")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+func UptimePageHandler(w http.ResponseWriter, r *http.Request) {
+	ServeTemplate(w, r, "uptime.html", nil)
+}
+
+func getUptimeReport(timeRange TimeRange) (UptimeReport, error) {
+	report := UptimeReport{Range: timeRange, Routes: []RouteUptime{}}
+
+	// Configured routes are listed even before their first check
+	byRoute := make(map[string]*RouteUptime)
+	var order []string
+	routeFor := func(route string) *RouteUptime {
+		if byRoute[route] == nil {
+			byRoute[route] = &RouteUptime{Route: route, History: []UptimeCheck{}}
+			order = append(order, route)
+		}
+		return byRoute[route]
+	}
+	for _, route := range strings.Split(config.UptimeRoutes, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			routeFor(route)
+		}
+	}
+
+	where := Filter{From: timeRange.From, To: timeRange.To}.where()
+	rows, err := db.DB.Query(`
+		SELECT timestamp, route, COALESCE(status_code, 0), COALESCE(latency_ms, 0), COALESCE(checksum, ''), COALESCE(error, '')
+		FROM uptime_checks
+		WHERE `+where.String()+`
+		ORDER BY route, timestamp`, where.params()...)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	latency := make(map[string]float64)
+	var checks, up int
+	for rows.Next() {
+		var route string
+		var check UptimeCheck
+		if err := rows.Scan(&check.Time, &route, &check.StatusCode, &check.Latency, &check.Checksum, &check.Error); err != nil {
+			return report, err
+		}
+		check.Up = jobs.UptimeUp(check.StatusCode, check.Error)
+
+		stats := routeFor(route)
+		if stats.Latest != nil && check.Checksum != "" && stats.Latest.Checksum != "" && check.Checksum != stats.Latest.Checksum {
+			stats.ChecksumChanges++
+		}
+		stats.Checks++
+		latency[route] += check.Latency
+		if check.Up {
+			stats.Up++
+			up++
+		}
+		checks++
+
+		stats.History = append(stats.History, check)
+		if len(stats.History) > uptimeHistory {
+			stats.History = stats.History[1:]
+		}
+		stats.Latest = &stats.History[len(stats.History)-1]
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	for _, route := range order {
+		stats := byRoute[route]
+		if stats.Checks > 0 {
+			stats.Availability = percentOf(stats.Up, stats.Checks)
+			stats.AvgLatency = latency[route] / float64(stats.Checks)
+		}
+		report.Routes = append(report.Routes, *stats)
+	}
+	if checks > 0 {
+		report.Availability = percentOf(up, checks)
+	}
+	return report, nil
+}
+
+func percentOf(part, total int) *float64 {
+	percent := float64(part) / float64(total) * 100
+	return &percent
+}
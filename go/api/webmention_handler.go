@@ -0,0 +1,213 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"server/config"
+	"server/db"
+	"server/logs"
+	"server/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebmentionHandler is the receiving endpoint from https://www.w3.org/TR/webmention/.
+// Mentions are only queued here; jobs.VerifyWebmentions fetches each source later
+// and checks that it really links to the target.
+func WebmentionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	source := r.PostForm.Get("source")
+	target := r.PostForm.Get("target")
+
+	sourceURL, err := url.Parse(source)
+	if err != nil || !isWebURL(sourceURL) {
+		http.Error(w, "source must be an http(s) URL", http.StatusBadRequest)
+		return
+	}
+
+	targetURL, err := url.Parse(target)
+	if err != nil || !isWebURL(targetURL) {
+		http.Error(w, "target must be an http(s) URL", http.StatusBadRequest)
+		return
+	}
+
+	// Store the re-serialized URLs, which percent-encode anything that doesn't belong in one
+	source = sourceURL.String()
+	target = targetURL.String()
+
+	if source == target {
+		http.Error(w, "source and target must differ", http.StatusBadRequest)
+		return
+	}
+
+	slug, ok := webmentionSlug(targetURL)
+	if !ok {
+		http.Error(w, "target does not accept webmentions", http.StatusBadRequest)
+		return
+	}
+
+	post, err := loadBlogPost(slug)
+	if err != nil || !isPublished(post, time.Now()) {
+		http.Error(w, "target does not accept webmentions", http.StatusBadRequest)
+		return
+	}
+
+	// Re-sending a mention re-verifies it, which is how sources report updates and deletions
+	_, err = db.DB.Exec(`
+		INSERT INTO webmentions (source, target, slug, status, received)
+		VALUES (?, ?, ?, 'queued', ?)
+		ON CONFLICT (source, target) DO UPDATE SET
+			status = 'queued',
+			error = NULL,
+			attempts = 0,
+			next_attempt = NULL,
+			received = excluded.received
+	`, source, target, slug, time.Now().UTC())
+	if err != nil {
+		logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to queue webmention")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("Webmention queued for verification\n"))
+}
+
+func isWebURL(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// webmentionSlug returns the blog slug a target URL points at, if it points at one of ours.
+func webmentionSlug(target *url.URL) (string, bool) {
+	site, err := url.Parse(config.BaseURL)
+	if err != nil || !strings.EqualFold(target.Host, site.Host) {
+		return "", false
+	}
+
+	slug, found := strings.CutPrefix(target.Path, "/blog/")
+	slug = strings.TrimSuffix(slug, "/")
+	if !found || slug == "" || strings.Contains(slug, "/") {
+		return "", false
+	}
+	return slug, true
+}
+
+func loadApprovedWebmentions(slug string) ([]types.Webmention, error) {
+	return queryWebmentions(`
+		SELECT id, source, target, slug, status, moderation, author_name, author_url, title, content, error, received
+		FROM webmentions
+		WHERE slug = ? AND status = 'verified' AND moderation = 'approved'
+		ORDER BY received ASC
+	`, slug)
+}
+
+func queryWebmentions(query string, args ...any) ([]types.Webmention, error) {
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mentions []types.Webmention
+	for rows.Next() {
+		var mention types.Webmention
+		var authorName, authorURL, title, content, mentionErr sql.NullString
+		err := rows.Scan(&mention.ID, &mention.Source, &mention.Target, &mention.Slug, &mention.Status,
+			&mention.Moderation, &authorName, &authorURL, &title, &content, &mentionErr, &mention.Received)
+		if err != nil {
+			return nil, err
+		}
+		mention.AuthorName = authorName.String
+		mention.AuthorURL = authorURL.String
+		mention.Title = title.String
+		mention.Content = content.String
+		mention.Error = mentionErr.String
+		mentions = append(mentions, mention)
+	}
+
+	return mentions, rows.Err()
+}
+
+// WebmentionAdminHandler lists mentions by moderation state (GET ?moderation=pending),
+// moderates one (POST {"id": 1, "moderation": "approved"}) or deletes one (DELETE ?id=1).
+func WebmentionAdminHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		moderation := r.URL.Query().Get("moderation")
+		if moderation == "" {
+			moderation = "pending"
+		}
+
+		mentions, err := queryWebmentions(`
+			SELECT id, source, target, slug, status, moderation, author_name, author_url, title, content, error, received
+			FROM webmentions
+			WHERE moderation = ?
+			ORDER BY received DESC
+			LIMIT 200
+		`, moderation)
+		if err != nil {
+			logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to list webmentions")
+			return
+		}
+		if mentions == nil {
+			mentions = []types.Webmention{}
+		}
+		json.NewEncoder(w).Encode(mentions)
+
+	case http.MethodPost:
+		var update struct {
+			ID         int    `json:"id"`
+			Moderation string `json:"moderation"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if update.Moderation != "pending" && update.Moderation != "approved" && update.Moderation != "rejected" {
+			http.Error(w, "moderation must be pending, approved or rejected", http.StatusBadRequest)
+			return
+		}
+
+		result, err := db.DB.Exec("UPDATE webmentions SET moderation = ? WHERE id = ?", update.Moderation, update.ID)
+		if err != nil {
+			logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to moderate webmention")
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			http.Error(w, "Webmention not found", http.StatusNotFound)
+			return
+		}
+		logs.HTTPSuccess(w, r, "Webmention "+update.Moderation)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "Invalid webmention ID", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := db.DB.Exec("DELETE FROM webmentions WHERE id = ?", id); err != nil {
+			logs.HTTPError(w, r, err, http.StatusInternalServerError, "Failed to delete webmention")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
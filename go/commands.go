@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"server/api"
+	"server/db"
+	"server/logs"
+	"time"
+)
+
+// runCommand handles one-off subcommands such as `./server preview my-post`.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "preview":
+		return previewCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "purge-ip":
+		return purgeIPCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func previewCommand(args []string) error {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	ttl := flags.Duration("ttl", 72*time.Hour, "how long the preview link stays valid")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: server preview [-ttl 72h] <slug>")
+	}
+
+	previewURL, err := api.PreviewURL(flags.Arg(0), *ttl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(previewURL)
+	return nil
+}
+
+// exportCommand writes every matching log row, following cursors page by page, e.g.
+// `./server export -format clf -status 5xx -from 2025-01-01 > errors.log`.
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	values := url.Values{}
+	for _, name := range []string{"table", "format", "from", "to", "ip", "route", "status", "method", "class", "ua", "level", "cursor", "limit"} {
+		flags.Func(name, "filter or option, as for /api/admin/export", func(value string) error {
+			values.Set(name, value)
+			return nil
+		})
+	}
+	out := flags.String("out", "", "file to write to instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	query, err := api.ParseExportQuery(values)
+	if err != nil {
+		return err
+	}
+	if err := db.InitDatabase(); err != nil {
+		return err
+	}
+
+	file := os.Stdout
+	if *out != "" {
+		if file, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+	writer := bufio.NewWriter(file)
+	defer writer.Flush()
+
+	for header := true; ; header = false {
+		last, count, err := api.ExportLogs(writer, query, header)
+		if err != nil {
+			return err
+		}
+		if count < query.Limit {
+			return nil
+		}
+		query.After = last
+	}
+}
+
+// purgeIPCommand removes everything stored about one address, as
+// /api/admin/privacy/purge does, e.g. `./server purge-ip 203.0.113.7`.
+func purgeIPCommand(args []string) error {
+	if len(args) != 1 || args[0] == logs.UntrackedAddr {
+		return fmt.Errorf("usage: server purge-ip <ip>")
+	}
+	if err := db.InitDatabase(); err != nil {
+		return err
+	}
+
+	purged, err := logs.PurgeIP(args[0])
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"access_logs", "dev_logs", "comments"} {
+		fmt.Printf("%s: %d\n", table, purged[table])
+	}
+	return nil
+}
@@ -10,8 +10,10 @@ import (
 	"server/api"
 	"server/config"
 	"server/db"
+	"server/geoip"
 	"server/jobs"
 	"server/logs"
+	"server/search"
 	"strings"
 	"time"
 )
@@ -24,6 +26,54 @@ func Init() {
 		panic("Failed to initialize logging database: " + err.Error())
 	}
 
+	go func() {
+		if err := logs.ClassifyStoredUserAgents(); err != nil {
+			logs.WARN("Failed to classify stored user agents", map[string]any{
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	if err := logs.ClearStreamDurations(); err != nil {
+		logs.WARN("Failed to clear stream durations", map[string]any{
+			"error": err.Error(),
+		})
+	}
+
+	// Renderers create their own subdirectories, but the readiness check needs the
+	// cache directory to exist before anything is cached
+	if err := os.MkdirAll(config.CacheDir, 0755); err != nil {
+		logs.WARN("Failed to create the cache directory", map[string]any{
+			"dir":   config.CacheDir,
+			"error": err.Error(),
+		})
+	}
+
+	if err := geoip.Init(); err != nil {
+		logs.WARN("GeoIP lookups are limited", map[string]any{
+			"error": err.Error(),
+		})
+	}
+
+	if mode := config.PrivacyMode; mode != "" && mode != logs.PrivacyTruncate && mode != logs.PrivacyHash {
+		logs.WARN("Unknown privacy mode, truncating IPs", map[string]any{
+			"mode": mode,
+		})
+	}
+
+	if err := search.Init(); err != nil {
+		logs.WARN("Full-text search is disabled", map[string]any{
+			"error": err.Error(),
+		})
+	}
+	go func() {
+		if err := api.SyncBlogIndex(); err != nil {
+			logs.WARN("Failed to sync blog search index", map[string]any{
+				"error": err.Error(),
+			})
+		}
+	}()
+
 	if config.CompileTypeScript {
 		go compileTypeScript()
 	}
@@ -40,6 +90,12 @@ func Init() {
 		})
 		panic("Failed to schedule jobs: " + err.Error())
 	}
+	jobs.Register(jobs.Job{
+		Spec:  "45 * * * * *",
+		Func:  api.SyncBlogIndex,
+		Name:  "SyncBlogIndex",
+		Quiet: true,
+	})
 }
 
 func initTemplates() error {
@@ -53,6 +109,7 @@ func initTemplates() error {
 		},
 		"processContent": processPostContent,
 		"getBacklinks":   getBacklinks,
+		"metaTags":       api.MetaTags,
 	}
 
 	templatesDir := "templates"
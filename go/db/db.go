@@ -64,7 +64,95 @@ func InitDatabase() error {
 			user_id INTEGER NOT NULL,
 			FOREIGN KEY (user_id) REFERENCES ebwg_users(id)
 		);
+		CREATE TABLE IF NOT EXISTS webmentions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			slug TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'queued',
+			moderation TEXT NOT NULL DEFAULT 'pending',
+			author_name TEXT,
+			author_url TEXT,
+			title TEXT,
+			content TEXT,
+			error TEXT,
+			received DATETIME,
+			verified DATETIME,
+			UNIQUE (source, target)
+		);
+		CREATE TABLE IF NOT EXISTS comments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			slug TEXT NOT NULL,
+			parent_id INTEGER,
+			name TEXT NOT NULL,
+			body TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			remote_addr TEXT,
+			user_agent TEXT,
+			created DATETIME,
+			FOREIGN KEY (parent_id) REFERENCES comments(id)
+		);
+		CREATE TABLE IF NOT EXISTS privacy_salts (
+			day TEXT PRIMARY KEY,
+			salt BLOB NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS uptime_checks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME,
+			route TEXT NOT NULL,
+			status_code INTEGER,
+			latency_ms REAL,
+			checksum TEXT,
+			error TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_uptime_checks_route ON uptime_checks(route, timestamp);
+		CREATE INDEX IF NOT EXISTS idx_comments_slug ON comments(slug, status);
+		CREATE INDEX IF NOT EXISTS idx_access_logs_timestamp ON access_logs(timestamp);
 	`)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return addMissingColumns()
+}
+
+// Columns added to tables after they first shipped. CREATE TABLE IF NOT EXISTS
+// leaves existing tables alone, so these are added when missing.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"access_logs", "referrer", "TEXT"},
+	{"access_logs", "ua_browser", "TEXT"},
+	{"access_logs", "ua_version", "TEXT"},
+	{"access_logs", "ua_os", "TEXT"},
+	{"access_logs", "ua_device", "TEXT"},
+	{"access_logs", "ua_crawler", "TEXT"},
+	{"access_logs", "country", "TEXT"},
+	{"access_logs", "asn", "INTEGER"},
+	{"access_logs", "as_org", "TEXT"},
+	{"access_logs", "duration_us", "INTEGER"},
+	{"webmentions", "attempts", "INTEGER NOT NULL DEFAULT 0"},
+	{"webmentions", "next_attempt", "DATETIME"},
+}
+
+func addMissingColumns() error {
+	for _, migration := range columnMigrations {
+		var exists bool
+		err := DB.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?",
+			migration.table, migration.column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = DB.Exec("ALTER TABLE " + migration.table + " ADD COLUMN " + migration.column + " " + migration.definition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,76 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"server/config"
+	"strings"
+)
+
+type Location struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+var databases []*database
+
+// Init opens the configured country and ASN databases. Either can be left unset,
+// and lookups return an empty Location when no database has the address.
+func Init() error {
+	databases = nil
+	var errs []error
+	for _, path := range []string{config.GeoIPCountryDB, config.GeoIPASNDB} {
+		if path == "" {
+			continue
+		}
+		db, err := openDatabase(path)
+		if err != nil {
+			errs = append(errs, errors.New(path+": "+err.Error()))
+			continue
+		}
+		databases = append(databases, db)
+	}
+	return errors.Join(errs...)
+}
+
+func Enabled() bool {
+	return len(databases) > 0
+}
+
+// Lookup finds the country and network of an address. Fields the databases
+// don't cover are left empty.
+func Lookup(addr string) Location {
+	var location Location
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return location
+	}
+
+	for _, db := range databases {
+		record, err := db.lookup(ip)
+		if err != nil || record == nil {
+			continue
+		}
+
+		if location.Country == "" {
+			location.Country = isoCode(record["country"])
+		}
+		if location.Country == "" {
+			location.Country = isoCode(record["registered_country"])
+		}
+		if location.ASN == 0 {
+			location.ASN = uint(asUint(record["autonomous_system_number"]))
+		}
+		if location.ASOrg == "" {
+			location.ASOrg, _ = record["autonomous_system_organization"].(string)
+		}
+	}
+	return location
+}
+
+func isoCode(value any) string {
+	country, _ := value.(map[string]any)
+	code, _ := country["iso_code"].(string)
+	return code
+}
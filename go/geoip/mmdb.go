@@ -0,0 +1,276 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// A reader for the MaxMind DB format, enough to look up records in GeoLite2 and
+// compatible databases. See https://maxmind.github.io/MaxMind-DB/ for the layout.
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+type database struct {
+	path       string
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+func openDatabase(path string) (*database, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := parseDatabase(file)
+	if err != nil {
+		return nil, err
+	}
+	db.path = path
+	return db, nil
+}
+
+func parseDatabase(file []byte) (*database, error) {
+	markerAt := bytes.LastIndex(file, metadataMarker)
+	if markerAt < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	metadata, _, err := (decoder{file[markerAt+len(metadataMarker):]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	fields, ok := metadata.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	db := &database{}
+	db.nodeCount = uint(asUint(fields["node_count"]))
+	db.recordSize = uint(asUint(fields["record_size"]))
+	db.ipVersion = uint(asUint(fields["ip_version"]))
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(markerAt) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+	db.tree = file[:treeSize]
+	db.data = file[treeSize+16 : markerAt]
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// lookup returns the record for an address, or nil if the database has none.
+func (db *database) lookup(ip net.IP) (map[string]any, error) {
+	node, bits := uint(0), ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+
+	value, _, err := (decoder{db.data}).decode(node - db.nodeCount - 16)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+func (db *database) record(node, bit uint) uint {
+	b := db.tree
+	switch db.recordSize {
+	case 24:
+		offset := node*6 + bit*3
+		if offset+3 > uint(len(b)) {
+			return db.nodeCount
+		}
+		return uint(b[offset])<<16 | uint(b[offset+1])<<8 | uint(b[offset+2])
+	case 28:
+		base := node * 7
+		if base+7 > uint(len(b)) {
+			return db.nodeCount
+		}
+		if bit == 0 {
+			return uint(b[base+3]&0xf0)<<20 | uint(b[base])<<16 | uint(b[base+1])<<8 | uint(b[base+2])
+		}
+		return uint(b[base+3]&0x0f)<<24 | uint(b[base+4])<<16 | uint(b[base+5])<<8 | uint(b[base+6])
+	default:
+		offset := node*8 + bit*4
+		if offset+4 > uint(len(b)) {
+			return db.nodeCount
+		}
+		return uint(binary.BigEndian.Uint32(b[offset:]))
+	}
+}
+
+type decoder struct {
+	buf []byte
+}
+
+var errCorrupt = errors.New("corrupt MaxMind DB data")
+
+func (d decoder) decode(offset uint) (any, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d decoder) decodeDepth(offset uint, depth int) (any, uint, error) {
+	if depth > 32 || offset >= uint(len(d.buf)) {
+		return nil, 0, errCorrupt
+	}
+	control := d.buf[offset]
+	offset++
+	kind := uint(control >> 5)
+	if kind == 0 {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errCorrupt
+		}
+		kind = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	if kind == 1 {
+		size := uint(control>>3) & 3
+		if offset+size+1 > uint(len(d.buf)) {
+			return nil, 0, errCorrupt
+		}
+		pointer := uint(control & 7)
+		if size == 3 {
+			pointer = 0
+		}
+		for _, b := range d.buf[offset : offset+size+1] {
+			pointer = pointer<<8 | uint(b)
+		}
+		pointer += []uint{0, 2048, 526336, 0}[size]
+		value, _, err := d.decodeDepth(pointer, depth+1)
+		return value, offset + size + 1, err
+	}
+
+	size := uint(control & 0x1f)
+	if size >= 29 {
+		extra := size - 28
+		if offset+extra > uint(len(d.buf)) {
+			return nil, 0, errCorrupt
+		}
+		n := uint(0)
+		for _, b := range d.buf[offset : offset+extra] {
+			n = n<<8 | uint(b)
+		}
+		size = []uint{29, 285, 65821}[extra-1] + n
+		offset += extra
+	}
+
+	// Every map entry and array element takes at least a byte, so a larger size
+	// is corrupt and would otherwise allocate up to 16M entries up front
+	if (kind == 7 || kind == 11) && size > uint(len(d.buf))-offset {
+		return nil, 0, errCorrupt
+	}
+
+	switch kind {
+	case 7: // map
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decodeDepth(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, _ := key.(string)
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // boolean, stored in the size
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errCorrupt
+	}
+	payload := d.buf[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case 2: // string
+		return string(payload), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case 4: // bytes
+		return payload, offset, nil
+	case 5, 6, 9, 10: // uint16, uint32, uint64, uint128
+		n := uint64(0)
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case 8: // int32
+		n := uint32(0)
+		for _, b := range payload {
+			n = n<<8 | uint32(b)
+		}
+		return int64(int32(n)), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown MaxMind DB type %d", kind)
+}
+
+func asUint(value any) uint64 {
+	switch v := value.(type) {
+	case uint64:
+		return v
+	case int64:
+		if v > 0 {
+			return uint64(v)
+		}
+	}
+	return 0
+}
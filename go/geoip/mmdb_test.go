@@ -0,0 +1,336 @@
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"server/config"
+	"strings"
+	"testing"
+)
+
+// The fixtures are written by testdata/build_fixtures.go
+var fixtures = []struct {
+	path       string
+	ipVersion  uint
+	recordSize uint
+}{
+	{"testdata/test-ipv4-24.mmdb", 4, 24},
+	{"testdata/test-ipv6-28.mmdb", 6, 28},
+	{"testdata/test-ipv6-32.mmdb", 6, 32},
+}
+
+func TestOpenDatabaseMetadata(t *testing.T) {
+	for _, fixture := range fixtures {
+		db, err := openDatabase(fixture.path)
+		if err != nil {
+			t.Fatalf("%s: %v", fixture.path, err)
+		}
+		if db.ipVersion != fixture.ipVersion || db.recordSize != fixture.recordSize {
+			t.Errorf("%s: got IPv%d with %d bit records, want IPv%d with %d bit records",
+				fixture.path, db.ipVersion, db.recordSize, fixture.ipVersion, fixture.recordSize)
+		}
+		if db.nodeCount == 0 || uint(len(db.tree)) != db.nodeCount*db.recordSize/4 {
+			t.Errorf("%s: tree of %d bytes for %d nodes", fixture.path, len(db.tree), db.nodeCount)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		ip      string
+		country string
+		asn     uint64
+		ipv6    bool // Only in the IPv6 fixtures
+	}{
+		{ip: "1.2.3.4", country: "AU", asn: 13335},
+		{ip: "1.2.3.0", country: "AU", asn: 13335},
+		{ip: "1.2.3.255", country: "AU", asn: 13335},
+		{ip: "8.8.8.8", country: "US", asn: 15169},
+		{ip: "8.8.4.4", country: "US", asn: 15170},
+		{ip: "::ffff:8.8.8.8", country: "US", asn: 15169},
+		{ip: "81.2.69.160", asn: 4200000000},
+		{ip: "2001:db8::1", country: "DE", ipv6: true},
+		{ip: "2001:db8:ffff:ffff::1", country: "DE", ipv6: true},
+	}
+	misses := []string{"1.2.4.0", "1.2.2.255", "8.8.9.1", "81.2.69.127", "81.2.69.192", "127.0.0.1", "2001:db9::1", "::1"}
+
+	for _, fixture := range fixtures {
+		db, err := openDatabase(fixture.path)
+		if err != nil {
+			t.Fatalf("%s: %v", fixture.path, err)
+		}
+
+		for _, test := range tests {
+			record, err := db.lookup(net.ParseIP(test.ip))
+			if err != nil {
+				t.Errorf("%s: lookup %s: %v", fixture.path, test.ip, err)
+				continue
+			}
+			if test.ipv6 && fixture.ipVersion == 4 {
+				if record != nil {
+					t.Errorf("%s: lookup %s in an IPv4 database = %v, want nil", fixture.path, test.ip, record)
+				}
+				continue
+			}
+			if record == nil {
+				t.Errorf("%s: lookup %s = nil, want a record", fixture.path, test.ip)
+				continue
+			}
+			if got := isoCode(record["country"]); got != test.country {
+				t.Errorf("%s: lookup %s country = %q, want %q", fixture.path, test.ip, got, test.country)
+			}
+			if got := asUint(record["autonomous_system_number"]); got != test.asn {
+				t.Errorf("%s: lookup %s ASN = %d, want %d", fixture.path, test.ip, got, test.asn)
+			}
+		}
+
+		for _, ip := range misses {
+			record, err := db.lookup(net.ParseIP(ip))
+			if err != nil || record != nil {
+				t.Errorf("%s: lookup %s = %v, %v, want nil, nil", fixture.path, ip, record, err)
+			}
+		}
+	}
+}
+
+// 81.2.69.128/26 holds one of each remaining type, a long string and pointers
+func TestLookupDecodesEveryType(t *testing.T) {
+	db, err := openDatabase("testdata/test-ipv6-32.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := db.lookup(net.ParseIP("81.2.69.130"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"registered_country":             map[string]any{"iso_code": "GB"},
+		"autonomous_system_number":       uint64(4200000000),
+		"autonomous_system_organization": strings.Repeat("Example Networks ", 20),
+		"location":                       map[string]any{"latitude": 51.5142, "longitude": -0.0931, "accuracy_radius": uint64(100)},
+		"is_anycast":                     true,
+		"subdivisions":                   []any{map[string]any{"iso_code": "ENG"}},
+		"offset":                         int64(-300),
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("got %#v\nwant %#v", record, want)
+	}
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	defer func(country, asn string) {
+		config.GeoIPCountryDB, config.GeoIPASNDB = country, asn
+		databases = nil
+	}(config.GeoIPCountryDB, config.GeoIPASNDB)
+
+	config.GeoIPCountryDB = "testdata/test-ipv6-28.mmdb"
+	config.GeoIPASNDB = "testdata/test-ipv4-24.mmdb"
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]Location{
+		"1.2.3.4":     {Country: "AU", ASN: 13335, ASOrg: "Cloudflare, Inc."},
+		" 8.8.8.8 ":   {Country: "US", ASN: 15169, ASOrg: "Google LLC"},
+		"81.2.69.130": {Country: "GB", ASN: 4200000000, ASOrg: strings.Repeat("Example Networks ", 20)},
+		"2001:db8::5": {Country: "DE"},
+		"10.0.0.1":    {},
+		"not an ip":   {},
+	}
+	for addr, want := range tests {
+		if got := Lookup(addr); got != want {
+			t.Errorf("Lookup(%q) = %+v, want %+v", addr, got, want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("x", n) }
+
+	tests := []struct {
+		name string
+		data []byte
+		want any
+	}{
+		{"empty string", []byte{0x40}, ""},
+		{"string", []byte{0x43, 'a', 'b', 'c'}, "abc"},
+		{"string of 28", append([]byte{0x5c}, long(28)...), long(28)},
+		{"string of 29", append([]byte{0x5d, 0x00}, long(29)...), long(29)},
+		{"string of 284", append([]byte{0x5d, 0xff}, long(284)...), long(284)},
+		{"string of 285", append([]byte{0x5e, 0x00, 0x00}, long(285)...), long(285)},
+		{"string of 65821", append([]byte{0x5f, 0x00, 0x00, 0x00}, long(65821)...), long(65821)},
+		{"double", []byte{0x68, 0x40, 0x09, 0x21, 0xfb, 0x54, 0x44, 0x2d, 0x18}, 3.141592653589793},
+		{"bytes", []byte{0x82, 0x01, 0x02}, []byte{1, 2}},
+		{"uint16", []byte{0xa2, 0x01, 0xf4}, uint64(500)},
+		{"uint16 zero", []byte{0xa0}, uint64(0)},
+		{"uint32", []byte{0xc4, 0xff, 0xff, 0xff, 0xff}, uint64(4294967295)},
+		{"map", []byte{0xe1, 0x41, 'k', 0x41, 'v'}, map[string]any{"k": "v"}},
+		{"int32", []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xff}, int64(-1)},
+		{"int32 short", []byte{0x02, 0x01, 0x01, 0x00}, int64(256)},
+		{"uint64", []byte{0x08, 0x02, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(18446744073709551615)},
+		{"uint128 low bits", []byte{0x02, 0x03, 0x01, 0x00}, uint64(256)},
+		{"array", []byte{0x02, 0x04, 0x41, 'a', 0xa1, 0x07}, []any{"a", uint64(7)}},
+		{"true", []byte{0x01, 0x07}, true},
+		{"false", []byte{0x00, 0x07}, false},
+		{"float", []byte{0x04, 0x08, 0x3f, 0xc0, 0x00, 0x00}, 1.5},
+	}
+
+	for _, test := range tests {
+		got, next, err := (decoder{test.data}).decode(0)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+		if next != uint(len(test.data)) {
+			t.Errorf("%s: next offset %d, want %d", test.name, next, len(test.data))
+		}
+	}
+}
+
+func TestDecodePointers(t *testing.T) {
+	target := []byte{0x42, 'o', 'k'}
+
+	tests := []struct {
+		name    string
+		pointer []byte
+		offset  int // Where the pointer resolves to
+	}{
+		{"11 bit", []byte{0x23, 0xff}, 0x3ff},
+		{"19 bit", []byte{0x29, 0x00, 0x10}, 2048 + 0x10010},
+		{"27 bit", []byte{0x30, 0x00, 0x00, 0x05}, 526336 + 5},
+		{"32 bit", []byte{0x3f, 0x00, 0x08, 0x10, 0x00}, 0x81000}, // The control byte's low bits are ignored
+	}
+
+	for _, test := range tests {
+		data := make([]byte, test.offset+len(target))
+		copy(data, test.pointer)
+		copy(data[test.offset:], target)
+
+		got, next, err := (decoder{data}).decode(0)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got != "ok" {
+			t.Errorf("%s: got %#v, want \"ok\"", test.name, got)
+		}
+		// Decoding continues after the pointer, not after what it points to
+		if next != uint(len(test.pointer)) {
+			t.Errorf("%s: next offset %d, want %d", test.name, next, len(test.pointer))
+		}
+	}
+
+	// A map whose key and value are pointers into earlier data
+	data := []byte{0x41, 'k', 0x41, 'v', 0xe1, 0x20, 0x00, 0x20, 0x02}
+	got, _, err := (decoder{data}).decode(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]any{"k": "v"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("map of pointers: got %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated string", []byte{0x45, 'a'}},
+		{"truncated size", []byte{0x5e, 0x00}},
+		{"truncated extended type", []byte{0x01}},
+		{"truncated pointer", []byte{0x28, 0x00}},
+		{"pointer past the end", []byte{0x20, 0x10}},
+		{"pointer to itself", []byte{0x20, 0x00}},
+		{"pointers in a cycle", []byte{0x20, 0x02, 0x20, 0x00}},
+		{"map larger than the data", []byte{0xff, 0xff, 0xff, 0xff}},
+		{"array larger than the data", []byte{0x1f, 0x04, 0xff, 0xff, 0xff}},
+		{"map missing a value", []byte{0xe1, 0x41, 'k'}},
+		{"double of 4 bytes", []byte{0x64, 0, 0, 0, 0}},
+		{"float of 8 bytes", []byte{0x08, 0x08, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"unknown type", []byte{0x00, 0x09}},
+	}
+
+	for _, test := range tests {
+		if value, _, err := (decoder{test.data}).decode(0); err == nil {
+			t.Errorf("%s: decoded %#v, want an error", test.name, value)
+		}
+	}
+
+	if _, _, err := (decoder{[]byte{0x20, 0x00}}).decode(0); !errors.Is(err, errCorrupt) {
+		t.Errorf("pointer to itself: got %v, want errCorrupt", err)
+	}
+}
+
+func TestParseDatabaseRejects(t *testing.T) {
+	file, err := os.ReadFile("testdata/test-ipv6-28.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	markerAt := bytes.LastIndex(file, metadataMarker)
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"no marker", file[:markerAt]},
+		{"no metadata", file[:markerAt+len(metadataMarker)]},
+		{"tree larger than the file", append(append([]byte{}, metadataMarker...), file[markerAt+len(metadataMarker):]...)},
+		{"metadata is not a map", append(append([]byte{}, metadataMarker...), 0x41, 'x')},
+		{"unsupported record size", append(append([]byte{}, metadataMarker...), 0xe1, 0x4b, 'r', 'e', 'c', 'o', 'r', 'd', '_', 's', 'i', 'z', 'e', 0xa1, 0x10)},
+	}
+
+	for _, test := range tests {
+		if _, err := parseDatabase(test.file); err == nil {
+			t.Errorf("%s: parsed, want an error", test.name)
+		}
+	}
+}
+
+// Damaged databases must fail or return wrong answers, never panic or hang
+func TestCorruptDatabasesDontPanic(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("1.2.3.4"), net.ParseIP("8.8.4.4"), net.ParseIP("81.2.69.130"),
+		net.ParseIP("2001:db8::1"), net.ParseIP("255.255.255.255"), net.ParseIP("ffff::1"),
+	}
+	check := func(name string, file []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("%s: panic: %v", name, r)
+			}
+		}()
+		db, err := parseDatabase(file)
+		if err != nil {
+			return
+		}
+		for _, ip := range ips {
+			db.lookup(ip)
+		}
+	}
+
+	for _, fixture := range fixtures {
+		file, err := os.ReadFile(fixture.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for size := range file {
+			check(fixture.path+" truncated", file[:size])
+		}
+		for i := range file {
+			for _, value := range []byte{0x00, 0xff, file[i] ^ 0x80} {
+				damaged := bytes.Clone(file)
+				damaged[i] = value
+				check(fixture.path+" damaged", damaged)
+			}
+		}
+	}
+}
@@ -0,0 +1,326 @@
+//go:build ignore
+
+// Writes the MaxMind DB fixtures used by mmdb_test.go. Run from geoip/ with
+//
+//	go run testdata/build_fixtures.go
+//
+// Every fixture holds the same networks: an IPv4 database with 24 bit records,
+// and IPv6 databases with 28 and 32 bit records that also cover 2001:db8::/32.
+// The writer follows https://maxmind.github.io/MaxMind-DB/ independently of the
+// reader, and deduplicates repeated values with pointers like real databases do.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+type network struct {
+	cidr   string
+	record map[string]any
+}
+
+var networks = []network{
+	{"1.2.3.0/24", map[string]any{
+		"country":                        map[string]any{"iso_code": "AU", "names": map[string]any{"en": "Australia"}},
+		"autonomous_system_number":       uint32(13335),
+		"autonomous_system_organization": "Cloudflare, Inc.",
+	}},
+	{"8.8.8.0/24", map[string]any{
+		"country":                        map[string]any{"iso_code": "US", "names": map[string]any{"en": "United States"}},
+		"autonomous_system_number":       uint32(15169),
+		"autonomous_system_organization": "Google LLC",
+	}},
+	// Shares its country and organization with 8.8.8.0/24, which the writer stores as pointers
+	{"8.8.4.0/24", map[string]any{
+		"country":                        map[string]any{"iso_code": "US", "names": map[string]any{"en": "United States"}},
+		"autonomous_system_number":       uint32(15170),
+		"autonomous_system_organization": "Google LLC",
+	}},
+	// No country, so lookups fall back to registered_country. The organization is
+	// long enough to need the two byte size extension.
+	{"81.2.69.128/26", map[string]any{
+		"registered_country":             map[string]any{"iso_code": "GB"},
+		"autonomous_system_number":       uint64(4200000000),
+		"autonomous_system_organization": strings.Repeat("Example Networks ", 20),
+		"location":                       map[string]any{"latitude": 51.5142, "longitude": -0.0931, "accuracy_radius": uint16(100)},
+		"is_anycast":                     true,
+		"subdivisions":                   []any{map[string]any{"iso_code": "ENG"}},
+		"offset":                         int32(-300),
+	}},
+	{"2001:db8::/32", map[string]any{
+		"country": map[string]any{"iso_code": "DE"},
+	}},
+}
+
+func main() {
+	for _, fixture := range []struct {
+		path       string
+		ipVersion  int
+		recordSize int
+	}{
+		{"testdata/test-ipv4-24.mmdb", 4, 24},
+		{"testdata/test-ipv6-28.mmdb", 6, 28},
+		{"testdata/test-ipv6-32.mmdb", 6, 32},
+	} {
+		if err := os.WriteFile(fixture.path, build(fixture.ipVersion, fixture.recordSize), 0644); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("wrote", fixture.path)
+	}
+}
+
+type node struct {
+	children [2]*node
+	data     int // Offset in the data section plus one, zero for none
+}
+
+func build(ipVersion, recordSize int) []byte {
+	root := &node{}
+	w := writer{dedupe: true}
+	for _, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ip := ipNet.IP
+		ones, _ := ipNet.Mask.Size()
+		if ip.To4() != nil {
+			if ipVersion == 6 {
+				// IPv4 lives under ::/96, not the ::ffff:0:0/96 To16 gives
+				ip, ones = append(make(net.IP, 12), ip.To4()...), ones+96
+			} else {
+				ip = ip.To4()
+			}
+		} else if ipVersion == 4 {
+			continue
+		}
+
+		offset := w.value(n.record)
+		current := root
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if current.children[bit] == nil {
+				current.children[bit] = &node{}
+			}
+			current = current.children[bit]
+		}
+		current.data = offset + 1
+	}
+
+	// Number the internal nodes breadth first, the order real databases use
+	var nodes []*node
+	index := map[*node]int{}
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		current := queue[0]
+		index[current] = len(nodes)
+		nodes = append(nodes, current)
+		for _, child := range current.children {
+			if child != nil && child.data == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	nodeCount := len(nodes)
+	recordValue := func(child *node) uint32 {
+		switch {
+		case child == nil:
+			return uint32(nodeCount)
+		case child.data != 0:
+			return uint32(nodeCount + 16 + child.data - 1)
+		default:
+			return uint32(index[child])
+		}
+	}
+
+	var tree bytes.Buffer
+	for _, n := range nodes {
+		left, right := recordValue(n.children[0]), recordValue(n.children[1])
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+			tree.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+			tree.WriteByte(byte(left>>24&0x0f)<<4 | byte(right>>24&0x0f))
+			tree.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			tree.Write(binary.BigEndian.AppendUint32(nil, left))
+			tree.Write(binary.BigEndian.AppendUint32(nil, right))
+		}
+	}
+
+	var metadata writer
+	metadata.value(map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "cnqso-Test",
+		"languages":                   []any{"en"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"description":                 map[string]any{"en": "Fixture for the geoip package tests"},
+	})
+
+	var file bytes.Buffer
+	file.Write(tree.Bytes())
+	file.Write(make([]byte, 16))
+	file.Write(w.buf.Bytes())
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	file.Write(metadata.buf.Bytes())
+	return file.Bytes()
+}
+
+// writer encodes values into a data section. With dedupe on, a value that was
+// already written, including map keys, is written again as a pointer to it.
+type writer struct {
+	buf     bytes.Buffer
+	written map[string]int
+	dedupe  bool
+}
+
+func (w *writer) value(v any) int {
+	if w.written == nil {
+		w.written = map[string]int{}
+	}
+
+	var encoded bytes.Buffer
+	w.encode(&encoded, v)
+	key := encoded.String()
+	if offset, ok := w.written[key]; ok && w.dedupe {
+		return offset
+	}
+	offset := w.buf.Len()
+	w.buf.Write(encoded.Bytes())
+	w.written[key] = offset
+	return offset
+}
+
+func (w *writer) encode(out *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		control(out, 2, len(v))
+		out.WriteString(v)
+	case float64:
+		control(out, 3, 8)
+		out.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+	case uint16:
+		writeUint(out, 5, uint64(v))
+	case uint32:
+		writeUint(out, 6, uint64(v))
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		control(out, 7, len(v))
+		for _, key := range keys {
+			w.child(out, key)
+			w.child(out, v[key])
+		}
+	case int32:
+		control(out, 8, 4)
+		out.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+	case uint64:
+		writeUint(out, 9, v)
+	case []any:
+		control(out, 11, len(v))
+		for _, item := range v {
+			w.child(out, item)
+		}
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		control(out, 14, size)
+	default:
+		log.Fatalf("unsupported value %T", v)
+	}
+}
+
+// child writes a value nested in a map or array, as a pointer if it is a map or
+// string that was written before.
+func (w *writer) child(out *bytes.Buffer, v any) {
+	if w.dedupe {
+		var encoded bytes.Buffer
+		w.encode(&encoded, v)
+		if offset, ok := w.written[encoded.String()]; ok {
+			pointer(out, offset)
+			return
+		}
+		if _, isMap := v.(map[string]any); isMap || isLongString(v) {
+			// Written out of line so later copies can point at it
+			pointer(out, w.value(v))
+			return
+		}
+	}
+	w.encode(out, v)
+}
+
+func isLongString(v any) bool {
+	s, ok := v.(string)
+	return ok && len(s) > 4
+}
+
+func writeUint(out *bytes.Buffer, kind int, n uint64) {
+	var payload []byte
+	for ; n > 0; n >>= 8 {
+		payload = append([]byte{byte(n)}, payload...)
+	}
+	control(out, kind, len(payload))
+	out.Write(payload)
+}
+
+func control(out *bytes.Buffer, kind, size int) {
+	first := byte(kind << 5)
+	var extended []byte
+	if kind > 7 {
+		first = 0
+		extended = []byte{byte(kind - 7)}
+	}
+
+	switch {
+	case size < 29:
+		out.WriteByte(first | byte(size))
+		out.Write(extended)
+	case size < 285:
+		out.WriteByte(first | 29)
+		out.Write(extended)
+		out.WriteByte(byte(size - 29))
+	case size < 65821:
+		out.WriteByte(first | 30)
+		out.Write(extended)
+		out.Write(binary.BigEndian.AppendUint16(nil, uint16(size-285)))
+	default:
+		size -= 65821
+		out.WriteByte(first | 31)
+		out.Write(extended)
+		out.Write([]byte{byte(size >> 16), byte(size >> 8), byte(size)})
+	}
+}
+
+func pointer(out *bytes.Buffer, offset int) {
+	switch {
+	case offset < 1<<11:
+		out.Write([]byte{0x20 | byte(offset>>8), byte(offset)})
+	case offset < 1<<19+2048:
+		offset -= 2048
+		out.Write([]byte{0x28 | byte(offset>>16), byte(offset >> 8), byte(offset)})
+	case offset < 1<<27+526336:
+		offset -= 526336
+		out.Write([]byte{0x30 | byte(offset>>24), byte(offset >> 16), byte(offset >> 8), byte(offset)})
+	default:
+		out.Write(append([]byte{0x38}, binary.BigEndian.AppendUint32(nil, uint32(offset))...))
+	}
+}
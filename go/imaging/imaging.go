@@ -0,0 +1,63 @@
+package imaging
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+
+	_ "image/gif"
+
+	"github.com/nfnt/resize"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+const jpegQuality = 85
+
+func Decode(path string) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	return image.Decode(file)
+}
+
+// Extension is the file extension Save uses for an image decoded as format.
+func Extension(format string) string {
+	if format == "png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Save writes img as a PNG if it was decoded from one, and as a JPEG otherwise.
+func Save(path string, img image.Image, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if format == "png" {
+		err = png.Encode(file, img)
+	} else {
+		err = jpeg.Encode(file, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Thumbnail scales img down to fit within maxWidth x maxHeight, keeping its aspect ratio.
+func Thumbnail(img image.Image, maxWidth, maxHeight uint) image.Image {
+	return resize.Thumbnail(maxWidth, maxHeight, img, resize.Lanczos3)
+}
+
+// ResizeWidth scales img to width pixels wide, keeping its aspect ratio.
+func ResizeWidth(img image.Image, width uint) image.Image {
+	return resize.Resize(width, 0, img, resize.Lanczos3)
+}
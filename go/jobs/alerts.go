@@ -0,0 +1,278 @@
+package jobs
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"server/db"
+	"server/logs"
+)
+
+const (
+	alertTimeFormat = "2006-01-02 15:04:05"
+	alertRepeat     = 6 * time.Hour // Still-firing alerts are sent again this often
+	alertRoutePath  = "CASE WHEN instr(url, '?') > 0 THEN substr(url, 1, instr(url, '?') - 1) ELSE url END"
+)
+
+type Alert struct {
+	Rule    string         `json:"rule"`
+	Key     string         `json:"key"` // Tells apart alerts from one rule, like each new 5xx route
+	Summary string         `json:"summary"`
+	Details map[string]any `json:"details,omitempty"`
+	Firing  bool           `json:"firing"` // False when the alert has resolved
+	Time    time.Time      `json:"time"`
+}
+
+type alertRule struct {
+	Name  string
+	Check func(now time.Time) ([]Alert, error) // Returns the alerts currently firing
+}
+
+// Alerts that are firing, resolutions that no channel has accepted yet, and when
+// each firing alert was last delivered, all by rule and key
+var firing = struct {
+	sync.Mutex
+	alerts   map[string]Alert
+	resolved map[string]Alert
+	sent     map[string]time.Time
+}{alerts: make(map[string]Alert), resolved: make(map[string]Alert), sent: make(map[string]time.Time)}
+
+func alertRules() []alertRule {
+	return []alertRule{
+		{Name: "error rate", Check: errorRate(15*time.Minute, 0.05, 20)},
+		{Name: "new 5xx route", Check: newErrorRoutes(15*time.Minute, 7*24*time.Hour)},
+		{Name: "job failures", Check: jobFailures(time.Hour)},
+		{Name: "job not run", Check: jobNotRun("ScrapePetrarchan", 13*time.Hour)},
+		{Name: "job not run", Check: jobNotRun("GetWordle", 25*time.Hour)},
+	}
+}
+
+// EvaluateAlerts runs every rule and notifies the configured channels of alerts
+// that started firing, are still firing after alertRepeat, or have resolved. An
+// alert only counts as sent once a channel accepts it, so failed deliveries are
+// tried again on the next run.
+func EvaluateAlerts() error {
+	now := time.Now().UTC()
+	channels := alertChannels()
+
+	var failed []string
+	var current []Alert
+	var failedRules []string
+	for _, rule := range alertRules() {
+		alerts, err := rule.Check(now)
+		if err != nil {
+			failed = append(failed, rule.Name+": "+err.Error())
+			failedRules = append(failedRules, rule.Name)
+			continue
+		}
+		for _, alert := range alerts {
+			alert.Rule = rule.Name
+			alert.Firing = true
+			alert.Time = now
+			current = append(current, alert)
+		}
+	}
+
+	active := make(map[string]bool)
+	var notify []Alert
+	firing.Lock()
+	// Keep the alerts of rules that failed as they were rather than resolving them
+	for key, alert := range firing.alerts {
+		if slices.Contains(failedRules, alert.Rule) {
+			active[key] = true
+		}
+	}
+	for _, alert := range current {
+		key := alertKey(alert)
+		active[key] = true
+		firing.alerts[key] = alert
+		delete(firing.resolved, key) // Firing again before the resolution went out
+		if last, sent := firing.sent[key]; !sent || now.Sub(last) >= alertRepeat {
+			notify = append(notify, alert)
+		}
+	}
+
+	for key, alert := range firing.alerts {
+		if active[key] {
+			continue
+		}
+		delete(firing.alerts, key)
+		// Channels that never heard it fire don't need to hear it resolve
+		if _, sent := firing.sent[key]; sent {
+			alert.Firing = false
+			alert.Time = now
+			firing.resolved[key] = alert
+		}
+	}
+	for _, alert := range firing.resolved {
+		notify = append(notify, alert)
+	}
+	firing.Unlock()
+
+	for _, alert := range notify {
+		state := "Alert resolved: "
+		if alert.Firing {
+			state = "Alert firing: "
+		}
+		logs.WARN(state+alert.Summary, map[string]any{"rule": alert.Rule, "key": alert.Key, "details": alert.Details})
+
+		// With no channels configured the log above is the notification
+		delivered := len(channels) == 0
+		for _, channel := range channels {
+			if err := channel.Send(alert); err != nil {
+				failed = append(failed, fmt.Sprintf("sending to %s: %v", channel.Name(), err))
+				continue
+			}
+			delivered = true
+		}
+		if !delivered {
+			continue
+		}
+
+		key := alertKey(alert)
+		firing.Lock()
+		if alert.Firing {
+			firing.sent[key] = now
+		} else {
+			delete(firing.resolved, key)
+			delete(firing.sent, key)
+		}
+		firing.Unlock()
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("alerts: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func alertKey(alert Alert) string {
+	return alert.Rule + "/" + alert.Key
+}
+
+// errorRate fires when more than threshold of the requests in the window were 5xx,
+// once there are enough requests for the rate to mean anything.
+func errorRate(window time.Duration, threshold float64, minRequests int) func(time.Time) ([]Alert, error) {
+	return func(now time.Time) ([]Alert, error) {
+		var total, failures int
+		err := db.DB.QueryRow(`
+			SELECT COUNT(*), COALESCE(SUM(status_code >= 500), 0)
+			FROM access_logs
+			WHERE timestamp >= ?
+		`, now.Add(-window).Format(alertTimeFormat)).Scan(&total, &failures)
+		if err != nil || total < minRequests {
+			return nil, err
+		}
+
+		rate := float64(failures) / float64(total)
+		if rate <= threshold {
+			return nil, nil
+		}
+		return []Alert{{
+			Key:     "5xx",
+			Summary: fmt.Sprintf("%.1f%% of requests failed with 5xx in the last %s", rate*100, window),
+			Details: map[string]any{"requests": total, "errors": failures, "threshold": threshold},
+		}}, nil
+	}
+}
+
+// newErrorRoutes fires for each route that returned a 5xx in the window without
+// having done so at any point in the lookback before it.
+func newErrorRoutes(window, lookback time.Duration) func(time.Time) ([]Alert, error) {
+	return func(now time.Time) ([]Alert, error) {
+		since := now.Add(-window).Format(alertTimeFormat)
+		rows, err := db.DB.Query(`
+			SELECT `+alertRoutePath+` as route, COUNT(*), MAX(status_code)
+			FROM access_logs
+			WHERE timestamp >= ? AND status_code >= 500
+			GROUP BY route
+			HAVING route NOT IN (
+				SELECT `+alertRoutePath+`
+				FROM access_logs
+				WHERE timestamp >= ? AND timestamp < ? AND status_code >= 500
+			)
+		`, since, now.Add(-window-lookback).Format(alertTimeFormat), since)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var alerts []Alert
+		for rows.Next() {
+			var route string
+			var count, status int
+			if err := rows.Scan(&route, &count, &status); err != nil {
+				return nil, err
+			}
+			alerts = append(alerts, Alert{
+				Key:     route,
+				Summary: fmt.Sprintf("%s started returning %d", route, status),
+				Details: map[string]any{"route": route, "errors": count},
+			})
+		}
+		return alerts, rows.Err()
+	}
+}
+
+// jobFailures fires for each job that logged a failure in the window.
+func jobFailures(window time.Duration) func(time.Time) ([]Alert, error) {
+	return func(now time.Time) ([]Alert, error) {
+		rows, err := db.DB.Query(`
+			SELECT failed.message, failed.count, COALESCE(json_extract(latest.data, '$.error'), '')
+			FROM (
+				SELECT message, COUNT(*) as count, MAX(id) as latest_id
+				FROM dev_logs
+				WHERE timestamp >= ? AND level = 'ERROR' AND message LIKE 'Scheduled % failed'
+				GROUP BY message
+			) failed
+			JOIN dev_logs latest ON latest.id = failed.latest_id
+		`, now.Add(-window).Format(alertTimeFormat))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var alerts []Alert
+		for rows.Next() {
+			var message, lastError string
+			var count int
+			if err := rows.Scan(&message, &count, &lastError); err != nil {
+				return nil, err
+			}
+			job := strings.TrimSuffix(strings.TrimPrefix(message, "Scheduled "), " failed")
+			alerts = append(alerts, Alert{
+				Key:     job,
+				Summary: fmt.Sprintf("%s failed %d time(s) in the last %s", job, count, window),
+				Details: map[string]any{"job": job, "failures": count, "error": lastError},
+			})
+		}
+		return alerts, rows.Err()
+	}
+}
+
+// jobNotRun fires when no job whose name starts with prefix has succeeded within
+// maxAge. Time since startup counts, as run history does not survive a restart.
+func jobNotRun(prefix string, maxAge time.Duration) func(time.Time) ([]Alert, error) {
+	return func(now time.Time) ([]Alert, error) {
+		status.Lock()
+		last := status.started
+		for _, job := range status.jobs {
+			if strings.HasPrefix(job.Name, prefix) && job.LastSuccess.After(last) {
+				last = job.LastSuccess
+			}
+		}
+		status.Unlock()
+
+		if now.Sub(last) <= maxAge {
+			return nil, nil
+		}
+		return []Alert{{
+			Key:     prefix,
+			Summary: fmt.Sprintf("%s has not succeeded in %s", prefix, now.Sub(last).Truncate(time.Minute)),
+			Details: map[string]any{"job": prefix, "since": last.UTC(), "maxAge": maxAge.String()},
+		}}, nil
+	}
+}
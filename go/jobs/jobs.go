@@ -1,21 +1,45 @@
 package jobs
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"server/logs"
+	"server/metrics"
 
 	"github.com/robfig/cron/v3"
 )
 
 var scheduler *cron.Cron
 
+// Run history is kept in memory, so after a restart each job gets two of its
+// scheduled runs to succeed before it counts as overdue
+var status = struct {
+	sync.Mutex
+	started time.Time
+	jobs    []*JobStatus
+}{started: time.Now()}
+
+type JobStatus struct {
+	Name        string    `json:"name"`
+	Spec        string    `json:"spec"`
+	LastRun     time.Time `json:"lastRun"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+	NextRun     time.Time `json:"nextRun"`
+	Overdue     bool      `json:"overdue"` // Missed two scheduled runs in a row without a success
+
+	schedule cron.Schedule
+}
+
 type Job struct {
-	Spec string
-	Func func()
-	Name string
+	Spec  string
+	Func  func() error
+	Name  string
+	Quiet bool // Skip the "Running scheduled" log line for frequent jobs
 }
 
 func Init() error {
@@ -27,7 +51,8 @@ func Init() error {
 	scheduler = cron.New(
 		cron.WithLocation(loc),
 		cron.WithSeconds(),
-		cron.WithChain(cron.Recover(cron.DefaultLogger)),
+		// A run that outlasts its interval, like a slow webmention batch, skips the next one
+		cron.WithChain(cron.Recover(cron.DefaultLogger), cron.SkipIfStillRunning(cron.DefaultLogger)),
 	)
 
 	addJobs()
@@ -38,18 +63,78 @@ func Init() error {
 
 func registerJobs(jobs []Job) {
 	for _, job := range jobs {
-		_, err := scheduler.AddFunc(job.Spec, func() {
-			if job.Name != "" {
+		jobStatus := &JobStatus{Name: job.Name, Spec: job.Spec}
+		id, err := scheduler.AddFunc(job.Spec, func() {
+			if job.Name != "" && !job.Quiet {
 				logs.INFO("Running scheduled "+job.Name, nil)
 			}
-			job.Func()
+			runJob(job, jobStatus)
 		})
 		if err != nil {
 			log.Fatalf("failed to schedule %s: %v", job.Name, err)
 		}
+
+		jobStatus.schedule = scheduler.Entry(id).Schedule
+		status.Lock()
+		status.jobs = append(status.jobs, jobStatus)
+		status.Unlock()
+	}
+}
+
+// Register schedules jobs defined outside this package, such as those that
+// need the api package, which imports jobs.
+func Register(jobs ...Job) {
+	registerJobs(jobs)
+}
+
+// Statuses reports the run history of every scheduled job, in registration order.
+func Statuses() []JobStatus {
+	status.Lock()
+	defer status.Unlock()
+
+	// Schedules are evaluated in the time zone of the times passed to them
+	now := time.Now().In(scheduler.Location())
+	var statuses []JobStatus
+	for _, job := range status.jobs {
+		current := *job
+		since := status.started.In(now.Location())
+		if current.LastSuccess.After(since) {
+			since = current.LastSuccess.In(now.Location())
+		}
+		current.NextRun = current.schedule.Next(now)
+		current.Overdue = now.After(current.schedule.Next(current.schedule.Next(since)))
+		statuses = append(statuses, current)
+	}
+	return statuses
+}
+
+// runJob records the run in metrics and the job's status. A panic counts as a
+// failure and is passed on to cron's Recover wrapper.
+func runJob(job Job, jobStatus *JobStatus) {
+	start := time.Now()
+	var err error = errPanicked
+	defer func() {
+		metrics.ObserveJob(job.Name, time.Since(start), err != nil)
+
+		status.Lock()
+		defer status.Unlock()
+		jobStatus.LastRun = start
+		if err != nil {
+			jobStatus.LastError = err.Error()
+		} else {
+			jobStatus.LastSuccess = start
+			jobStatus.LastError = ""
+		}
+	}()
+
+	err = job.Func()
+	if err != nil {
+		logs.ERROR("Scheduled "+job.Name+" failed", map[string]any{"error": err.Error()})
 	}
 }
 
+var errPanicked = errors.New("job panicked")
+
 func addJobs() {
 	jobs := []Job{
 		{
@@ -67,6 +152,29 @@ func addJobs() {
 			Func: ScrapePetrarchan,
 			Name: "ScrapePetrarchan PM",
 		},
+		{
+			Spec:  "0 * * * * *",
+			Func:  VerifyWebmentions,
+			Name:  "VerifyWebmentions",
+			Quiet: true,
+		},
+		{
+			Spec: "0 15 4 * * *",
+			Func: EnforceRetention,
+			Name: "EnforceRetention",
+		},
+		{
+			Spec:  "15 */5 * * * *",
+			Func:  CheckUptime,
+			Name:  "CheckUptime",
+			Quiet: true,
+		},
+		{
+			Spec:  "30 * * * * *",
+			Func:  EvaluateAlerts,
+			Name:  "EvaluateAlerts",
+			Quiet: true,
+		},
 	}
 
 	registerJobs(jobs)
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"server/config"
+	"server/db"
+	"server/logs"
+)
+
+// EnforceRetention truncates the IPs and drops the user agents stored with
+// requests, comments and dev logs older than config.RetentionDays. Parsed user
+// agent columns and truncated IPs are kept, so older dashboards keep working.
+// It also deletes the expired salts of hashed IPs.
+func EnforceRetention() error {
+	now := time.Now().UTC()
+	if err := logs.RotateSalts(now); err != nil {
+		return err
+	}
+	if config.RetentionDays == "" {
+		return nil
+	}
+
+	days, err := strconv.Atoi(config.RetentionDays)
+	if err != nil || days <= 0 {
+		return fmt.Errorf("CNQSO_RETENTION_DAYS must be a positive number of days, got %q", config.RetentionDays)
+	}
+	cutoff := now.AddDate(0, 0, -days).Format("2006-01-02 15:04:05")
+
+	anonymized := make(map[string]int64)
+	for _, table := range []struct{ name, timeColumn string }{{"access_logs", "timestamp"}, {"comments", "created"}} {
+		count, err := truncateStoredIPs(table.name, table.timeColumn, cutoff)
+		if err != nil {
+			return err
+		}
+		result, err := db.DB.Exec("UPDATE "+table.name+" SET user_agent = NULL WHERE "+table.timeColumn+" < ? AND user_agent IS NOT NULL", cutoff)
+		if err != nil {
+			return err
+		}
+		agents, _ := result.RowsAffected()
+		anonymized[table.name] = count + agents
+	}
+
+	result, err := db.DB.Exec(`
+		UPDATE dev_logs SET data = json_remove(data, '$.RemoteAddr', '$.remote_addr', '$.UserAgent')
+		WHERE timestamp < ? AND json_valid(data)
+			AND (json_type(data, '$.RemoteAddr') IS NOT NULL OR json_type(data, '$.remote_addr') IS NOT NULL
+				OR json_type(data, '$.UserAgent') IS NOT NULL)
+	`, cutoff)
+	if err != nil {
+		return err
+	}
+	anonymized["dev_logs"], _ = result.RowsAffected()
+
+	if anonymized["access_logs"]+anonymized["comments"]+anonymized["dev_logs"] > 0 {
+		logs.INFO("Anonymized identifiers past retention", map[string]any{"days": days, "rows": anonymized})
+	}
+	return nil
+}
+
+// truncateStoredIPs rewrites the raw addresses older than cutoff one distinct
+// address at a time, as the truncation happens in Go.
+func truncateStoredIPs(table, timeColumn, cutoff string) (int64, error) {
+	rows, err := db.DB.Query("SELECT DISTINCT remote_addr FROM "+table+" WHERE "+timeColumn+" < ? AND remote_addr IS NOT NULL", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var addrs []string
+	for rows.Next() {
+		var addr string
+		if err := rows.Scan(&addr); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		addrs = append(addrs, addr)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, addr := range addrs {
+		truncated := logs.TruncateIP(logs.AddrIP(addr))
+		if truncated == addr {
+			continue
+		}
+		result, err := db.DB.Exec("UPDATE "+table+" SET remote_addr = ? WHERE remote_addr = ? AND "+timeColumn+" < ?", truncated, addr, cutoff)
+		if err != nil {
+			return total, err
+		}
+		count, _ := result.RowsAffected()
+		total += count
+	}
+	return total, nil
+}
@@ -2,27 +2,19 @@ package jobs
 
 import (
 	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"server/db"
+	"server/imaging"
 	"server/logs"
+	"server/search"
 	"strconv"
 	"strings"
 	"time"
 
-	_ "image/gif"
-
 	"github.com/gocolly/colly"
-	"github.com/nfnt/resize"
-	_ "golang.org/x/image/bmp"
-	_ "golang.org/x/image/tiff"
-	_ "golang.org/x/image/webp"
 )
 
 var KnownPostIDs map[string]bool
@@ -31,7 +23,7 @@ var Scraper = colly.NewCollector(
 	colly.UserAgent("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36"),
 )
 
-func ScrapePetrarchan() {
+func ScrapePetrarchan() error {
 	startTime := time.Now()
 	// logs.INFO("Starting catalog scrape...")
 
@@ -45,12 +37,12 @@ func ScrapePetrarchan() {
 
 	threads, err := fetchPetrarchanCatalog()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	threadsToScrape, newCount, updatedCount, err := processCatalogUpdates(threads)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	logs.INFO(fmt.Sprintf("Identified %d new threads and %d updated threads to scrape", newCount, updatedCount))
 
@@ -72,6 +64,7 @@ func ScrapePetrarchan() {
 
 	auditReplyCounts()
 	logs.INFO(fmt.Sprintf("Scrape complete in %s", time.Since(startTime)))
+	return nil
 }
 
 func loadKnownPostIDs() {
@@ -456,38 +449,15 @@ func downloadImage(imageURL, postID string) (string, error) {
 }
 
 func createThumbnail(imagePath string) error {
-	file, err := os.Open(imagePath)
+	img, format, err := imaging.Decode(imagePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return err
-	}
-
-	thumbnail := resize.Thumbnail(150, 150, img, resize.Lanczos3)
 
 	ext := filepath.Ext(imagePath)
 	thumbPath := strings.TrimSuffix(imagePath, ext) + "_thumb" + ext
 
-	thumbFile, err := os.Create(thumbPath)
-	if err != nil {
-		return err
-	}
-	defer thumbFile.Close()
-
-	switch format {
-	case "jpeg":
-		err = jpeg.Encode(thumbFile, thumbnail, &jpeg.Options{Quality: 85})
-	case "png":
-		err = png.Encode(thumbFile, thumbnail)
-	default:
-		err = jpeg.Encode(thumbFile, thumbnail, &jpeg.Options{Quality: 85})
-	}
-
-	return err
+	return imaging.Save(thumbPath, imaging.Thumbnail(img, 150, 150), format)
 }
 
 func storePost(post Post, threadID string, timestamp time.Time, imagePath string) error {
@@ -504,7 +474,15 @@ func storePost(post Post, threadID string, timestamp time.Time, imagePath string
 		post.Replies,
 		imagePath,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	err = search.IndexArchivePost(post.ID, threadID, post.Title, post.Poster, post.Body)
+	if err != nil {
+		logs.WARN(fmt.Sprintf("Failed to index post %s for search: %v", post.ID, err))
+	}
+	return nil
 }
 
 func ForceRegenerateThumbnails() error {
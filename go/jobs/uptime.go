@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"server/config"
+	"server/db"
+)
+
+const (
+	UptimeUserAgent = "cnqso-uptime/1.0 (bot)"
+	uptimeTimeout   = 10 * time.Second
+	uptimeRetention = 90 * 24 * time.Hour
+	uptimeMaxBody   = 10 << 20 // Bodies are hashed up to this size
+)
+
+var uptimeClient = &http.Client{
+	Timeout: uptimeTimeout,
+	// A redirect is an answer in itself, and following one could leave our listener
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// UptimeUp is whether a check found its route up: it answered with a 2xx or 3xx
+// and the body could be read. Both the job and the uptime report go by it.
+func UptimeUp(status int, errorText string) bool {
+	return errorText == "" && status >= 200 && status < 400
+}
+
+// CheckUptime requests each of config.UptimeRoutes through our own listener and
+// records the status, latency and a checksum of the body. It fails when any route
+// is down, so the job failure alert covers downtime.
+func CheckUptime() error {
+	base := localURL(config.Port)
+	now := time.Now().UTC()
+
+	var down []string
+	for _, route := range strings.Split(config.UptimeRoutes, ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+
+		status, latency, checksum, err := checkRoute(base + route)
+		var errorText string
+		if err != nil {
+			errorText = err.Error()
+		}
+		if !UptimeUp(status, errorText) {
+			if errorText != "" {
+				down = append(down, route+": "+errorText)
+			} else {
+				down = append(down, fmt.Sprintf("%s: %d", route, status))
+			}
+		}
+
+		_, dbErr := db.DB.Exec(`
+			INSERT INTO uptime_checks (timestamp, route, status_code, latency_ms, checksum, error)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, now, route, status, latency, checksum, errorText)
+		if dbErr != nil {
+			return dbErr
+		}
+	}
+
+	if _, err := db.DB.Exec("DELETE FROM uptime_checks WHERE timestamp < ?",
+		now.Add(-uptimeRetention).Format("2006-01-02 15:04:05")); err != nil {
+		return err
+	}
+
+	if len(down) > 0 {
+		return fmt.Errorf("routes down: %s", strings.Join(down, "; "))
+	}
+	return nil
+}
+
+func checkRoute(url string) (status int, latency float64, checksum string, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	req.Header.Set("User-Agent", UptimeUserAgent)
+
+	start := time.Now()
+	resp, err := uptimeClient.Do(req)
+	if err != nil {
+		return 0, float64(time.Since(start).Microseconds()) / 1000, "", err
+	}
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, io.LimitReader(resp.Body, uptimeMaxBody))
+	latency = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		return resp.StatusCode, latency, "", err
+	}
+	return resp.StatusCode, latency, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// localURL turns the listen address into a URL on the loopback interface.
+func localURL(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "http://127.0.0.1" + listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
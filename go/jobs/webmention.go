@@ -0,0 +1,268 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"server/db"
+	"server/logs"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocolly/colly"
+)
+
+const (
+	webmentionBatchSize   = 20
+	webmentionMaxContent  = 500
+	webmentionMaxAttempts = 6
+	webmentionRetryDelay  = 5 * time.Minute // Doubles with each failed attempt
+)
+
+var (
+	errPrivateAddress = errors.New("refusing to fetch a private address")
+
+	// Carrier-grade NAT space, which net.IP.IsPrivate doesn't cover
+	sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+)
+
+type queuedWebmention struct {
+	id       int
+	source   string
+	target   string
+	attempts int
+}
+
+type webmentionSource struct {
+	linked     bool
+	authorName string
+	authorURL  string
+	title      string
+	content    string
+}
+
+// VerifyWebmentions fetches the source of each queued webmention and checks that it
+// links to the target, as the receiver is required to do before displaying it.
+func VerifyWebmentions() error {
+	rows, err := db.DB.Query(`
+		SELECT id, source, target, attempts FROM webmentions
+		WHERE status = 'queued' AND (next_attempt IS NULL OR next_attempt <= ?)
+		ORDER BY received ASC
+		LIMIT ?
+	`, time.Now().UTC(), webmentionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var queued []queuedWebmention
+	for rows.Next() {
+		var mention queuedWebmention
+		if err := rows.Scan(&mention.id, &mention.source, &mention.target, &mention.attempts); err != nil {
+			logs.ERROR("Error scanning queued webmention", map[string]any{"error": err.Error()})
+			continue
+		}
+		queued = append(queued, mention)
+	}
+	rows.Close()
+
+	for _, mention := range queued {
+		verifyWebmention(mention)
+	}
+	return nil
+}
+
+func verifyWebmention(mention queuedWebmention) {
+	source, status, err := fetchWebmentionSource(mention.source, mention.target)
+
+	if status == http.StatusGone {
+		// The source was deleted, so the mention goes with it
+		if _, err := db.DB.Exec("DELETE FROM webmentions WHERE id = ?", mention.id); err != nil {
+			logs.ERROR("Error deleting webmention", map[string]any{"id": mention.id, "error": err.Error()})
+		}
+		return
+	}
+
+	if err != nil && transientFetchError(status, err) && mention.attempts+1 < webmentionMaxAttempts {
+		retryWebmention(mention, err)
+		return
+	}
+
+	if err == nil && !source.linked {
+		err = errors.New("source does not link to target")
+	}
+
+	if err != nil {
+		_, dbErr := db.DB.Exec("UPDATE webmentions SET status = 'invalid', error = ? WHERE id = ?", err.Error(), mention.id)
+		if dbErr != nil {
+			logs.ERROR("Error updating webmention", map[string]any{"id": mention.id, "error": dbErr.Error()})
+		}
+		logs.INFO("Rejected webmention", map[string]any{"source": mention.source, "target": mention.target, "reason": err.Error()})
+		return
+	}
+
+	_, err = db.DB.Exec(`
+		UPDATE webmentions
+		SET status = 'verified', error = NULL, author_name = ?, author_url = ?, title = ?, content = ?, verified = ?
+		WHERE id = ?
+	`, source.authorName, source.authorURL, source.title, source.content, time.Now().UTC(), mention.id)
+	if err != nil {
+		logs.ERROR("Error updating webmention", map[string]any{"id": mention.id, "error": err.Error()})
+		return
+	}
+	logs.INFO("Verified webmention", map[string]any{"source": mention.source, "target": mention.target})
+}
+
+// transientFetchError reports whether a failed fetch is worth retrying: timeouts,
+// DNS and connection failures, rate limiting and server errors. Anything else,
+// including a refused private address, is a definitive answer.
+func transientFetchError(status int, err error) bool {
+	if errors.Is(err, errPrivateAddress) {
+		return false
+	}
+	if status == 0 {
+		return true
+	}
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+}
+
+// retryWebmention leaves the mention queued with an exponential backoff.
+func retryWebmention(mention queuedWebmention, err error) {
+	delay := webmentionRetryDelay << mention.attempts
+	_, dbErr := db.DB.Exec("UPDATE webmentions SET attempts = attempts + 1, next_attempt = ?, error = ? WHERE id = ?",
+		time.Now().UTC().Add(delay), err.Error(), mention.id)
+	if dbErr != nil {
+		logs.ERROR("Error updating webmention", map[string]any{"id": mention.id, "error": dbErr.Error()})
+	}
+	logs.INFO("Retrying webmention later", map[string]any{"source": mention.source, "target": mention.target, "reason": err.Error(), "attempt": mention.attempts + 1})
+}
+
+func fetchWebmentionSource(sourceURL, targetURL string) (webmentionSource, int, error) {
+	var source webmentionSource
+	var status int
+
+	collector := newWebmentionCollector()
+
+	collector.OnResponse(func(r *colly.Response) {
+		status = r.StatusCode
+	})
+
+	collector.OnError(func(r *colly.Response, err error) {
+		status = r.StatusCode
+	})
+
+	collector.OnHTML("html", func(e *colly.HTMLElement) {
+		e.ForEach("a[href], link[href]", func(_ int, link *colly.HTMLElement) {
+			if sameURL(link.Request.AbsoluteURL(link.Attr("href")), targetURL) {
+				source.linked = true
+			}
+		})
+
+		// Prefer microformats2 (h-entry / h-card) and fall back to plain HTML metadata
+		scope := e.DOM.Find(".h-entry").First()
+		if scope.Length() == 0 {
+			scope = e.DOM
+		}
+
+		author := scope.Find(".p-author").First()
+		if author.Length() == 0 {
+			author = e.DOM.Find(".h-card").First()
+		}
+		if author.Length() > 0 {
+			source.authorName = author.Find(".p-name").First().Text()
+			if source.authorName == "" {
+				source.authorName = author.Text()
+			}
+			source.authorURL, _ = author.Attr("href")
+			if source.authorURL == "" {
+				source.authorURL, _ = author.Find(".u-url").First().Attr("href")
+			}
+			if source.authorURL != "" {
+				source.authorURL = e.Request.AbsoluteURL(source.authorURL)
+			}
+		}
+
+		source.title = e.ChildText("head > title")
+		source.content = scope.Find(".e-content, .p-content").First().Text()
+		if source.content == "" {
+			source.content = e.ChildAttr(`meta[name="description"]`, "content")
+		}
+	})
+
+	err := collector.Visit(sourceURL)
+
+	source.authorName = collapseText(source.authorName, 100)
+	source.title = collapseText(source.title, 200)
+	source.content = collapseText(source.content, webmentionMaxContent)
+	if !strings.HasPrefix(source.authorURL, "http://") && !strings.HasPrefix(source.authorURL, "https://") {
+		source.authorURL = ""
+	}
+
+	return source, status, err
+}
+
+// newWebmentionCollector returns a collector that refuses to connect to loopback,
+// private, shared or link-local addresses, since the source URL is chosen by the sender.
+func newWebmentionCollector() *colly.Collector {
+	collector := colly.NewCollector(
+		colly.UserAgent("cnqso.com webmention verifier (+https://cnqso.com)"),
+		colly.MaxBodySize(1<<20),
+		colly.AllowURLRevisit(),
+	)
+	collector.SetRequestTimeout(10 * time.Second)
+
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip) ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+
+	collector.WithTransport(&http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	})
+
+	return collector
+}
+
+func sameURL(a, b string) bool {
+	left, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	right, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(left.Host, right.Host) &&
+		strings.TrimSuffix(left.Path, "/") == strings.TrimSuffix(right.Path, "/")
+}
+
+// collapseText squashes whitespace and truncates to max runes.
+func collapseText(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:max])) + "…"
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -165,14 +164,16 @@ func writeToJSONFile(entries []WordleEntry, filename string) error {
 	return os.WriteFile(filename, buf.Bytes(), 0644)
 }
 
-func updateWordleDB() {
+func updateWordleDB() error {
 
 	todaysWordle, err := fetchTodaysWordle()
+	if err != nil {
+		return err
+	}
 	maxID := todaysWordle.DaysSinceLaunch
 	entries, err := getAllEntries(db.DB)
 	if err != nil {
-		logs.ERROR("Failed to fetch wordle data: %v", err)
-		return
+		return err
 	}
 	expected := make(map[int]bool, maxID+2)
 	for i, _ := range entries {
@@ -207,6 +208,7 @@ func updateWordleDB() {
 			logs.INFO("Successfully processed Wordle #%d: %s (%s)\n", entry.ID, entry.Word, entry.Date)
 		}
 	}
+	return nil
 }
 
 func pushToGithub() error {
@@ -227,12 +229,14 @@ func pushToGithub() error {
 	return nil
 }
 
-func GetWordle() {
-	updateWordleDB()
+func GetWordle() error {
+	if err := updateWordleDB(); err != nil {
+		return err
+	}
 
 	entries, err := getAllEntries(db.DB)
 	if err != nil {
-		log.Fatal("Failed to get entries:", err)
+		return err
 	}
 
 	if err := writeToTextFile(entries, "../wordle-data/answers.txt"); err != nil {
@@ -247,5 +251,5 @@ func GetWordle() {
 		logs.INFO("Successfully wrote answers.json")
 	}
 
-	pushToGithub()
+	return pushToGithub()
 }
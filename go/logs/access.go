@@ -2,6 +2,10 @@ package logs
 
 import (
 	"net/http"
+	"server/db"
+	"server/geoip"
+	"server/metrics"
+	"strings"
 	"time"
 )
 
@@ -22,7 +26,24 @@ func (rc *responseCapture) Write(b []byte) (int, error) {
 	return size, err
 }
 
-func AccessLogEntry(r *http.Request, statusCode int, responseTime int64, responseSize int64) {
+// Flush lets handlers behind the middleware stream responses, like the live dashboard.
+func (rc *responseCapture) Flush() {
+	if flusher, ok := rc.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rc *responseCapture) Unwrap() http.ResponseWriter {
+	return rc.ResponseWriter
+}
+
+// AccessLogEntry records a finished request. Streaming responses, like the live
+// dashboard, are open for as long as the client watches, so their duration isn't
+// a response time and is left out.
+func AccessLogEntry(r *http.Request, statusCode int, duration time.Duration, responseSize int64, streaming bool) {
+	if streaming {
+		duration = 0
+	}
 	entry := AccessEntry{
 		Timestamp:    time.Now().UTC(),
 		Level:        LevelInfo,
@@ -30,13 +51,19 @@ func AccessLogEntry(r *http.Request, statusCode int, responseTime int64, respons
 		Method:       r.Method,
 		URL:          r.URL.String(),
 		StatusCode:   statusCode,
-		ResponseTime: responseTime,
-		UserAgent:    r.UserAgent(),
-		RemoteAddr:   getRemoteAddr(r),
+		ResponseTime: duration.Milliseconds(),
+		DurationUS:   duration.Microseconds(),
+		UserAgent:    StoredUserAgent(r),
+		RemoteAddr:   StoredAddr(r),
 		RequestSize:  r.ContentLength,
 		ResponseSize: responseSize,
+		Referrer:     NormalizeReferrer(r.Referer()),
+		Client:       ParseUserAgent(r.UserAgent()),
+		Location:     geoip.Lookup(ClientIP(r)),
+		Streaming:    streaming,
 	}
 	logToOutput(entry, LevelInfo)
+	publish(entry)
 }
 
 func Middleware(next http.Handler) http.Handler {
@@ -51,12 +78,37 @@ func Middleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(capture, r)
 
-		responseTime := time.Since(start).Milliseconds()
-
-		AccessLogEntry(r, capture.statusCode, responseTime, capture.responseSize)
+		duration := time.Since(start)
+		streaming := strings.HasPrefix(capture.Header().Get("Content-Type"), "text/event-stream")
+		AccessLogEntry(r, capture.statusCode, duration, capture.responseSize, streaming)
+		if streaming {
+			metrics.ObserveStream(r.Pattern, metricsMethod(r.Method), capture.statusCode)
+		} else {
+			metrics.ObserveRequest(r.Pattern, metricsMethod(r.Method), capture.statusCode, duration)
+		}
 	})
 }
 
+// ClearStreamDurations drops the durations stored for live dashboard streams
+// before they were left out, which otherwise dominate every latency figure.
+func ClearStreamDurations() error {
+	_, err := db.DB.Exec(`
+		UPDATE access_logs SET response_time = NULL, duration_us = NULL
+		WHERE url LIKE '/api/dashboard/live%' AND (response_time IS NOT NULL OR duration_us IS NOT NULL)
+	`)
+	return err
+}
+
 func Handler(handler http.HandlerFunc) http.HandlerFunc {
 	return Middleware(http.HandlerFunc(handler)).ServeHTTP
 }
+
+// metricsMethod folds unusual methods together so scanners can't add series.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
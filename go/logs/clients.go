@@ -0,0 +1,96 @@
+package logs
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Substrings of user agents that belong to crawlers, scripts and monitors rather than people
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "scrapy", "headless", "lighthouse",
+	"curl", "wget", "python", "go-http-client", "java/", "okhttp", "libwww", "httpclient",
+	"facebookexternalhit", "preview", "monitor", "uptime", "verifier", "feed", "fetch",
+}
+
+// Query parameters kept on stored referrers. Anything else may be a token or session ID.
+var referrerParams = map[string]bool{
+	"utm_source": true, "utm_medium": true, "utm_campaign": true, "ref": true, "source": true,
+}
+
+// Referring domains that are search engines, matched against the end of the host
+var searchEngines = []struct {
+	domain string
+	name   string
+}{
+	{"google.", "Google"}, {"bing.com", "Bing"}, {"duckduckgo.com", "DuckDuckGo"},
+	{"search.yahoo.com", "Yahoo"}, {"yandex.", "Yandex"}, {"baidu.com", "Baidu"},
+	{"ecosia.org", "Ecosia"}, {"search.brave.com", "Brave"}, {"kagi.com", "Kagi"},
+	{"startpage.com", "Startpage"}, {"qwant.com", "Qwant"}, {"perplexity.ai", "Perplexity"},
+}
+
+func IsBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	if userAgent == "" {
+		return true
+	}
+	for _, marker := range botMarkers {
+		if strings.Contains(userAgent, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeReferrer reduces a Referer header to host and path, plus any campaign
+// parameters. Other query parameters and the fragment are dropped since they can
+// carry tokens and session IDs.
+func NormalizeReferrer(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" || strings.Trim(host, "abcdefghijklmnopqrstuvwxyz0123456789.-:") != "" {
+		return ""
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+
+	normalized := host + u.EscapedPath()
+	query := url.Values{}
+	for key, values := range u.Query() {
+		if referrerParams[strings.ToLower(key)] && len(values) > 0 {
+			query.Set(strings.ToLower(key), values[0])
+		}
+	}
+	if len(query) > 0 {
+		normalized += "?" + query.Encode()
+	}
+	return normalized
+}
+
+// ReferrerHost returns the host of a referrer stored by NormalizeReferrer, without www.
+func ReferrerHost(referrer string) string {
+	host, _, _ := strings.Cut(referrer, "/")
+	host, _, _ = strings.Cut(host, "?")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// SearchEngine names the search engine a referring host belongs to, or returns "".
+func SearchEngine(host string) string {
+	for _, engine := range searchEngines {
+		if strings.HasSuffix(engine.domain, ".") {
+			if strings.HasPrefix(host, engine.domain) || strings.Contains(host, "."+engine.domain) {
+				return engine.name
+			}
+		} else if host == engine.domain || strings.HasSuffix(host, "."+engine.domain) {
+			return engine.name
+		}
+	}
+	return ""
+}
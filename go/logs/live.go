@@ -0,0 +1,53 @@
+package logs
+
+import "sync"
+
+// Entries a subscriber can fall behind by before it is dropped
+const liveBuffer = 256
+
+// The live broker fans access entries out to dashboard streams. Publishing never
+// blocks the request being logged: a subscriber whose buffer is full is dropped
+// and its channel closed, and the client is expected to reconnect.
+var live = struct {
+	sync.Mutex
+	subscribers map[chan AccessEntry]struct{}
+}{subscribers: make(map[chan AccessEntry]struct{})}
+
+// Subscribe returns a channel of access entries and a function to unsubscribe. The
+// channel is closed when unsubscribed or when the subscriber was too slow.
+func Subscribe() (<-chan AccessEntry, func()) {
+	ch := make(chan AccessEntry, liveBuffer)
+
+	live.Lock()
+	live.subscribers[ch] = struct{}{}
+	live.Unlock()
+
+	return ch, func() {
+		live.Lock()
+		defer live.Unlock()
+		if _, ok := live.subscribers[ch]; ok {
+			delete(live.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func Subscribers() int {
+	live.Lock()
+	defer live.Unlock()
+	return len(live.subscribers)
+}
+
+func publish(entry AccessEntry) {
+	live.Lock()
+	defer live.Unlock()
+
+	for ch := range live.subscribers {
+		select {
+		case ch <- entry:
+		default:
+			delete(live.subscribers, ch)
+			close(ch)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package logs
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"server/db"
+	"server/geoip"
 	"server/types"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -30,18 +34,23 @@ type Entry struct {
 }
 
 type AccessEntry struct {
-	Timestamp    time.Time `json:"timestamp"`
-	Level        Level     `json:"level"`
-	Message      string    `json:"message"`
-	Method       string    `json:"method"`
-	URL          string    `json:"url"`
-	StatusCode   int       `json:"status_code,omitempty"`
-	ResponseTime int64     `json:"response_time_ms,omitempty"`
-	UserAgent    string    `json:"user_agent,omitempty"`
-	RemoteAddr   string    `json:"remote_addr,omitempty"`
-	RequestSize  int64     `json:"request_size,omitempty"`
-	ResponseSize int64     `json:"response_size,omitempty"`
-	Data         any       `json:"data,omitempty"`
+	Timestamp    time.Time      `json:"timestamp"`
+	Level        Level          `json:"level"`
+	Message      string         `json:"message"`
+	Method       string         `json:"method"`
+	URL          string         `json:"url"`
+	StatusCode   int            `json:"status_code,omitempty"`
+	ResponseTime int64          `json:"response_time_ms,omitempty"`
+	DurationUS   int64          `json:"duration_us,omitempty"`
+	UserAgent    string         `json:"user_agent,omitempty"`
+	RemoteAddr   string         `json:"remote_addr,omitempty"`
+	RequestSize  int64          `json:"request_size,omitempty"`
+	ResponseSize int64          `json:"response_size,omitempty"`
+	Referrer     string         `json:"referrer,omitempty"`
+	Client       UserAgent      `json:"client"`
+	Location     geoip.Location `json:"location"`
+	Streaming    bool           `json:"streaming,omitempty"`
+	Data         any            `json:"data,omitempty"`
 }
 
 func logToOutput(entry any, level Level) {
@@ -81,12 +90,30 @@ func logToOutput(entry any, level Level) {
 					dataJSON = string(jsonData)
 				}
 			}
-			db.DB.Exec("INSERT INTO access_logs (timestamp, method, url, status_code, response_time, remote_addr, request_size, response_size, user_agent, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-				e.Timestamp, e.Method, e.URL, e.StatusCode, e.ResponseTime, e.RemoteAddr, e.RequestSize, e.ResponseSize, e.UserAgent, dataJSON)
+			db.DB.Exec(`INSERT INTO access_logs (timestamp, method, url, status_code, response_time, duration_us, remote_addr, request_size, response_size, user_agent, referrer,
+				ua_browser, ua_version, ua_os, ua_device, ua_crawler, country, asn, as_org, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				e.Timestamp, e.Method, e.URL, e.StatusCode, nullDuration(e.ResponseTime, e.Streaming), nullDuration(e.DurationUS, e.Streaming), e.RemoteAddr, e.RequestSize, e.ResponseSize, e.UserAgent, e.Referrer,
+				e.Client.Browser, e.Client.Version, e.Client.OS, e.Client.Device, e.Client.Crawler,
+				nullString(e.Location.Country), nullUint(e.Location.ASN), nullString(e.Location.ASOrg), dataJSON)
 		}
 	}
 }
 
+// Geo columns stay NULL rather than empty when nothing is known, so breakdowns can
+// tell unknown apart from a lookup that was never made
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullUint(n uint) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+// Streams have no response time, and NULL keeps them out of the latency queries
+func nullDuration(n int64, streaming bool) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: !streaming}
+}
+
 func DEBUG(message string, data ...any) {
 	entry := Entry{
 		Timestamp: time.Now().UTC(),
@@ -148,7 +175,7 @@ func HTTPError(w http.ResponseWriter, r *http.Request, err error, status int, me
 		Timestamp: time.Now().UTC(),
 		Level:     LevelError,
 		Message:   message,
-		Data:      map[string]any{"error": err.Error(), "status": status, "method": r.Method, "route": r.URL.Path, "UserAgent": r.UserAgent(), "RemoteAddr": getRemoteAddr(r), "RequestSize": r.ContentLength},
+		Data:      map[string]any{"error": err.Error(), "status": status, "method": r.Method, "route": r.URL.Path, "UserAgent": StoredUserAgent(r), "RemoteAddr": StoredAddr(r), "RequestSize": r.ContentLength},
 	}
 	logToOutput(entry, LevelError)
 	w.WriteHeader(status)
@@ -167,3 +194,22 @@ func getRemoteAddr(r *http.Request) string {
 	}
 	return r.RemoteAddr
 }
+
+// ClientIP is the address the request came from, without the port or any later
+// proxy hops. It trusts X-Forwarded-For as sent, so it is only for display, storage
+// and GeoIP; limits and anything else a spoofed address could abuse use
+// TrustedClientIP.
+func ClientIP(r *http.Request) string {
+	return AddrIP(getRemoteAddr(r))
+}
+
+// AddrIP strips the port and any later proxy hops from a remote address as
+// access_logs has stored it.
+func AddrIP(remoteAddr string) string {
+	addr, _, _ := strings.Cut(remoteAddr, ",")
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,202 @@
+package logs
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"server/config"
+	"server/db"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PrivacyTruncate = "truncate"
+	PrivacyHash     = "hash"
+
+	// Stored in place of the address for requests that asked not to be tracked
+	UntrackedAddr = "-"
+	hashPrefix    = "h-"
+)
+
+// Hashes use a salt per UTC day, so one visitor keeps one identifier for the day
+// and can't be followed across days once the salt is deleted by RotateSalts
+var salts = struct {
+	sync.Mutex
+	day  string
+	salt []byte
+}{}
+
+// DoNotTrack reports whether the request asked not to be tracked, with either
+// DNT or Global Privacy Control, and config.HonorDNT is on.
+func DoNotTrack(r *http.Request) bool {
+	return config.HonorDNT && (r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1")
+}
+
+// StoredAddr is the client address as it goes into the database under the
+// configured privacy mode. Unknown modes truncate, the safer of the two.
+func StoredAddr(r *http.Request) string {
+	switch {
+	case DoNotTrack(r):
+		return UntrackedAddr
+	case config.PrivacyMode == PrivacyHash:
+		return HashIP(ClientIP(r), time.Now().UTC())
+	case config.PrivacyMode != "":
+		return TruncateIP(ClientIP(r))
+	}
+	return getRemoteAddr(r)
+}
+
+// StoredUserAgent is the user agent as it goes into the database.
+func StoredUserAgent(r *http.Request) string {
+	if DoNotTrack(r) {
+		return ""
+	}
+	return r.UserAgent()
+}
+
+// StoredIP turns an address typed into the dashboard into the identifier it is
+// stored as today. Identifiers that are already anonymized are returned as-is.
+func StoredIP(ip string) string {
+	if ip == UntrackedAddr || strings.HasPrefix(ip, hashPrefix) {
+		return ip
+	}
+	switch config.PrivacyMode {
+	case "":
+		return ip
+	case PrivacyHash:
+		return HashIP(ip, time.Now().UTC())
+	}
+	return TruncateIP(ip)
+}
+
+// TruncateIP zeroes the host part of an address, keeping the /24 of IPv4 and the
+// /48 of IPv6. Anything that doesn't parse is returned unchanged.
+func TruncateIP(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// HashIP is a keyed hash of the address with the salt for the day of now.
+// Without a salt it falls back to truncating rather than storing the address.
+func HashIP(addr string, now time.Time) string {
+	salt, err := daySalt(now.Format("2006-01-02"))
+	if err != nil {
+		ERROR("Failed to load privacy salt", map[string]any{"error": err.Error()})
+		return TruncateIP(addr)
+	}
+	return hashWith(addr, salt)
+}
+
+func hashWith(addr string, salt []byte) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(addr))
+	return hashPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// daySalt returns the salt for a day, creating it the first time it is needed.
+// Salts are kept in the database so identifiers survive restarts within the day.
+func daySalt(day string) ([]byte, error) {
+	salts.Lock()
+	defer salts.Unlock()
+
+	if salts.day == day {
+		return salts.salt, nil
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := db.DB.Exec("INSERT OR IGNORE INTO privacy_salts (day, salt) VALUES (?, ?)", day, salt); err != nil {
+		return nil, err
+	}
+	if err := db.DB.QueryRow("SELECT salt FROM privacy_salts WHERE day = ?", day).Scan(&salt); err != nil {
+		return nil, err
+	}
+
+	salts.day, salts.salt = day, salt
+	return salt, nil
+}
+
+// RotateSalts deletes the salts of days before yesterday, after which the hashes
+// made with them can no longer be matched to an address.
+func RotateSalts(now time.Time) error {
+	_, err := db.DB.Exec("DELETE FROM privacy_salts WHERE day < ?", now.AddDate(0, 0, -1).Format("2006-01-02"))
+	return err
+}
+
+// storedForms lists every identifier an address may have been stored as: raw,
+// with a port or proxy chain, truncated, or hashed with any salt still kept.
+func storedForms(ip string) ([]string, []string, error) {
+	exact := []string{ip}
+	prefixes := []string{ip + ":", "[" + ip + "]:", ip + ","}
+
+	rows, err := db.DB.Query("SELECT salt FROM privacy_salts")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var salt []byte
+		if err := rows.Scan(&salt); err != nil {
+			return nil, nil, err
+		}
+		exact = append(exact, hashWith(ip, salt))
+	}
+	return exact, prefixes, rows.Err()
+}
+
+// PurgeIP deletes the access and dev logs of an address and strips it from
+// comments, which are left for moderation to remove. Truncated addresses are
+// shared with other visitors, so rows stored that way are kept.
+func PurgeIP(ip string) (map[string]int64, error) {
+	exact, prefixes, err := storedForms(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	match := func(column string) (string, []any) {
+		var conditions []string
+		var args []any
+		for _, value := range exact {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+		for _, prefix := range prefixes {
+			conditions = append(conditions, "substr("+column+", 1, ?) = ?")
+			args = append(args, len(prefix), prefix)
+		}
+		return "(" + strings.Join(conditions, " OR ") + ")", args
+	}
+
+	purged := make(map[string]int64)
+	statements := []struct {
+		table  string
+		query  string
+		column string
+	}{
+		{"access_logs", "DELETE FROM access_logs WHERE ", "remote_addr"},
+		{"dev_logs", "DELETE FROM dev_logs WHERE ", "CASE WHEN json_valid(data) THEN COALESCE(json_extract(data, '$.RemoteAddr'), json_extract(data, '$.remote_addr')) END"},
+		{"comments", "UPDATE comments SET remote_addr = NULL, user_agent = NULL WHERE ", "remote_addr"},
+	}
+	for _, statement := range statements {
+		condition, args := match(statement.column)
+		result, err := db.DB.Exec(statement.query+condition, args...)
+		if err != nil {
+			return purged, err
+		}
+		purged[statement.table], _ = result.RowsAffected()
+	}
+	return purged, nil
+}
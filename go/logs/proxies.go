@@ -0,0 +1,74 @@
+package logs
+
+import (
+	"net"
+	"net/http"
+	"server/config"
+	"strings"
+	"sync"
+)
+
+var trustedProxies struct {
+	once     sync.Once
+	networks []*net.IPNet
+}
+
+// TrustedClientIP is the client address as reported by the last trusted proxy.
+// X-Forwarded-For is read from the right, skipping hops that are trusted proxies,
+// since everything to the left of them was written by the client. Without any
+// trusted proxies configured it is the connection's own address. Use it where a
+// spoofed address would matter, such as rate limits.
+func TrustedClientIP(r *http.Request) string {
+	ip := AddrIP(r.RemoteAddr)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := AddrIP(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(addr string) bool {
+	trustedProxies.once.Do(func() {
+		for _, entry := range strings.Split(config.TrustedProxies, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if !strings.Contains(entry, "/") {
+				if strings.Contains(entry, ":") {
+					entry += "/128"
+				} else {
+					entry += "/32"
+				}
+			}
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				WARN("Ignoring invalid trusted proxy", map[string]any{"proxy": entry, "error": err.Error()})
+				continue
+			}
+			trustedProxies.networks = append(trustedProxies.networks, network)
+		}
+	})
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
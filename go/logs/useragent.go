@@ -0,0 +1,189 @@
+package logs
+
+import (
+	"server/db"
+	"strings"
+)
+
+type UserAgent struct {
+	Browser string `json:"browser"`
+	Version string `json:"version,omitempty"` // Major version only, so point releases group together
+	OS      string `json:"os"`
+	Device  string `json:"device"` // desktop, mobile, tablet or bot
+	Crawler string `json:"crawler,omitempty"`
+}
+
+// Known crawlers, scripts and monitors, checked in order against the lowercased user agent
+var crawlers = []struct {
+	marker string
+	name   string
+}{
+	{"googlebot", "Googlebot"}, {"google-inspectiontool", "Googlebot"}, {"adsbot-google", "Googlebot"},
+	{"bingbot", "Bingbot"}, {"gptbot", "GPTBot"}, {"chatgpt-user", "ChatGPT-User"}, {"oai-searchbot", "OAI-SearchBot"},
+	{"claudebot", "ClaudeBot"}, {"claude-web", "ClaudeBot"}, {"perplexitybot", "PerplexityBot"},
+	{"applebot", "Applebot"}, {"duckduckbot", "DuckDuckBot"}, {"yandexbot", "YandexBot"},
+	{"baiduspider", "Baiduspider"}, {"bytespider", "Bytespider"}, {"ccbot", "CCBot"},
+	{"ahrefsbot", "AhrefsBot"}, {"semrushbot", "SemrushBot"}, {"mj12bot", "MJ12bot"}, {"dotbot", "DotBot"},
+	{"petalbot", "PetalBot"}, {"amazonbot", "Amazonbot"}, {"facebookexternalhit", "Facebook"},
+	{"meta-externalagent", "Facebook"}, {"twitterbot", "Twitterbot"}, {"slackbot", "Slackbot"},
+	{"discordbot", "Discordbot"}, {"telegrambot", "TelegramBot"}, {"linkedinbot", "LinkedInBot"},
+	{"mastodon", "Mastodon"}, {"uptimerobot", "UptimeRobot"}, {"cnqso-uptime", "Uptime check"}, {"lighthouse", "Lighthouse"},
+	{"headlesschrome", "HeadlessChrome"}, {"curl/", "curl"}, {"wget/", "Wget"},
+	{"python-requests", "python-requests"}, {"python-httpx", "python-httpx"}, {"aiohttp", "aiohttp"},
+	{"python-urllib", "python-urllib"}, {"go-http-client", "Go-http-client"}, {"okhttp", "okhttp"},
+	{"java/", "Java"}, {"libwww-perl", "libwww-perl"}, {"scrapy", "Scrapy"}, {"zgrab", "zgrab"},
+	{"masscan", "masscan"}, {"nuclei", "Nuclei"}, {"censysinspect", "Censys"}, {"expanse", "Expanse"},
+}
+
+// Browsers in detection order; Chromium-based browsers all claim Chrome and Safari,
+// and Chrome claims Safari, so the more specific tokens come first
+var browsers = []struct {
+	token string
+	name  string
+}{
+	{"edg/", "Edge"}, {"edga/", "Edge"}, {"edgios/", "Edge"}, {"opr/", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"}, {"yabrowser/", "Yandex Browser"}, {"vivaldi/", "Vivaldi"},
+	{"firefox/", "Firefox"}, {"fxios/", "Firefox"}, {"crios/", "Chrome"}, {"chrome/", "Chrome"},
+	{"version/", "Safari"},
+}
+
+// ParseUserAgent classifies a user agent string. Unrecognized clients that still look
+// automated per IsBot are reported with the "Other bot" crawler name.
+func ParseUserAgent(userAgent string) UserAgent {
+	lower := strings.ToLower(userAgent)
+	ua := UserAgent{Browser: "Other", OS: "Other", Device: "desktop"}
+
+	for _, crawler := range crawlers {
+		if strings.Contains(lower, crawler.marker) {
+			ua.Crawler = crawler.name
+			break
+		}
+	}
+	if ua.Crawler == "" && IsBot(userAgent) {
+		ua.Crawler = "Other bot"
+	}
+
+	switch {
+	case strings.Contains(lower, "windows"):
+		ua.OS = "Windows"
+	case strings.Contains(lower, "android"):
+		ua.OS = "Android"
+	case strings.Contains(lower, "iphone"), strings.Contains(lower, "ipad"), strings.Contains(lower, "ipod"):
+		ua.OS = "iOS"
+	case strings.Contains(lower, "mac os x"), strings.Contains(lower, "macintosh"):
+		ua.OS = "macOS"
+	case strings.Contains(lower, "cros "):
+		ua.OS = "ChromeOS"
+	case strings.Contains(lower, "linux"):
+		ua.OS = "Linux"
+	}
+
+	for _, browser := range browsers {
+		if i := strings.Index(lower, browser.token); i >= 0 {
+			if browser.name == "Safari" && !strings.Contains(lower, "safari/") {
+				continue
+			}
+			ua.Browser = browser.name
+			version := lower[i+len(browser.token):]
+			if end := strings.IndexAny(version, ". ;)"); end >= 0 {
+				version = version[:end]
+			}
+			ua.Version = version
+			break
+		}
+	}
+
+	switch {
+	case ua.Crawler != "":
+		ua.Device = "bot"
+	case strings.Contains(lower, "ipad"), strings.Contains(lower, "tablet"),
+		ua.OS == "Android" && !strings.Contains(lower, "mobile"):
+		ua.Device = "tablet"
+	case strings.Contains(lower, "mobile"), strings.Contains(lower, "iphone"), ua.OS == "Android":
+		ua.Device = "mobile"
+	}
+
+	return ua
+}
+
+// Rows classified per transaction by ClassifyStoredUserAgents, so live inserts
+// only ever wait on one short batch
+const classifyBatchSize = 1000
+
+// ClassifyStoredUserAgents fills in the user agent classification of access logs
+// written before it was recorded. It walks the table by id, classifying in Go and
+// updating each batch by primary key inside one transaction.
+func ClassifyStoredUserAgents() error {
+	parsed := make(map[string]UserAgent)
+	classified := 0
+	lastID := int64(0)
+
+	for {
+		rows, err := db.DB.Query(`
+			SELECT id, COALESCE(user_agent, '') FROM access_logs
+			WHERE id > ? AND ua_device IS NULL
+			ORDER BY id
+			LIMIT ?
+		`, lastID, classifyBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var ids []int64
+		var userAgents []string
+		for rows.Next() {
+			var id int64
+			var userAgent string
+			if err := rows.Scan(&id, &userAgent); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+			userAgents = append(userAgents, userAgent)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return err
+		}
+		update, err := tx.Prepare(`
+			UPDATE access_logs SET ua_browser = ?, ua_version = ?, ua_os = ?, ua_device = ?, ua_crawler = ?
+			WHERE id = ?
+		`)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for i, id := range ids {
+			ua, ok := parsed[userAgents[i]]
+			if !ok {
+				ua = ParseUserAgent(userAgents[i])
+				parsed[userAgents[i]] = ua
+			}
+			if _, err := update.Exec(ua.Browser, ua.Version, ua.OS, ua.Device, ua.Crawler, id); err != nil {
+				update.Close()
+				tx.Rollback()
+				return err
+			}
+		}
+		update.Close()
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		classified += len(ids)
+		lastID = ids[len(ids)-1]
+	}
+
+	if classified > 0 {
+		INFO("Classified stored user agents", map[string]any{"rows": classified, "user_agents": len(parsed)})
+	}
+	return nil
+}
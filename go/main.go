@@ -1,28 +1,52 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 	"server/api"
 	"server/config"
 	"server/core"
 	"server/logs"
+	"server/middleware"
 	"server/types"
 )
 
 var routes = []types.Route{
 	{Path: "/", Handler: api.IndexHandler},
 	{Path: "/health", Handler: api.HealthHandler},
+	{Path: "/health/live", Handler: api.HealthHandler},
+	{Path: "/health/ready", Handler: api.ReadinessHandler},
+	{Path: "/metrics", Handler: api.MetricsHandler},
 	{Path: "/upload", Handler: api.UploadHandler},
 	{Path: "/fetch", Handler: api.FetchHandler},
 	{Path: "/blog/", Handler: api.BlogHandler},
+	{Path: "/og/", Handler: api.OGImageHandler},
+	{Path: "/search", Handler: api.SearchHandler},
+	{Path: "/api/search", Handler: api.SearchAPIHandler},
+	{Path: "/webmention", Handler: api.WebmentionHandler},
+	{Path: "/comments", Handler: api.CommentHandler},
 	{Path: "/splits", Handler: api.SplitsHandler},
 	{Path: "/spirals/", Handler: api.SpiralsHandler},
 	{Path: "/reverse-wordle-solver", Handler: api.ReverseWordleHandler},
 
 	{Path: "/dashboard", Handler: api.DashboardPageHandler},
 	{Path: "/api/dashboard", Handler: api.DashboardHandler},
+	{Path: "/api/dashboard/live", Handler: middleware.RequireAdmin(api.LiveDashboardHandler)},
+	{Path: "/dashboard/content", Handler: api.ContentAnalyticsPageHandler},
+	{Path: "/api/dashboard/content", Handler: api.ContentAnalyticsHandler},
+	{Path: "/dashboard/uptime", Handler: api.UptimePageHandler},
+	{Path: "/api/dashboard/uptime", Handler: api.UptimeHandler},
+	{Path: "/dashboard/logs", Handler: middleware.RequireAdmin(api.DevLogsPageHandler)},
+	{Path: "/api/dashboard/logs", Handler: middleware.RequireAdmin(api.DevLogsHandler)},
 	{Path: "/dashboard/ip/", Handler: api.IPAnalyticsPageHandler},
 	{Path: "/api/dashboard/ip/", Handler: api.IPAnalyticsHandler},
+	{Path: "/admin/login", Handler: api.AdminLoginHandler},
+	{Path: "/admin/moderation", Handler: middleware.RequireAdmin(api.AdminModerationPageHandler)},
+	{Path: "/api/admin/export", Handler: middleware.RequireAdmin(api.ExportHandler)},
+	{Path: "/api/admin/privacy/purge", Handler: middleware.RequireAdmin(api.PurgeIPHandler)},
+	{Path: "/api/admin/comments", Handler: middleware.RequireAdmin(api.CommentAdminHandler)},
+	{Path: "/api/admin/webmentions", Handler: middleware.RequireAdmin(api.WebmentionAdminHandler)},
 	{Path: "/static/", Handler: api.StaticHandler},
 	{Path: "/petrarchive/", Handler: api.ArchiveHandler},
 	{Path: "/hexagons", Handler: api.HexagonsHandler},
@@ -31,21 +55,45 @@ var routes = []types.Route{
 	{Path: "/api/ebwg/", Handler: api.EBWGAPIHandler},
 	{Path: "/favicon.ico/", Handler: api.FaviconHandler},
 	{Path: "/robots.txt", Handler: api.RobotsHandler},
-	// {Path: "/sitemap.xml", Handler: api.SitemapHandler},
+	{Path: "/sitemap.xml", Handler: api.SitemapHandler},
 	{Path: "/security.txt", Handler: api.SecurityTxtHandler},
 	{Path: "/.well-known/security.txt", Handler: api.SecurityTxtHandler},
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	core.Init()
 
 	for _, route := range routes {
 		http.HandleFunc(route.Path, logs.Handler(route.Handler))
 	}
 
+	if config.MetricsAddr != "" {
+		go serveMetrics()
+	}
+
 	logs.INFO("Starting server", map[string]any{"port": config.Port})
 
 	if err := http.ListenAndServe(config.Port, nil); err != nil {
 		logs.ERROR("Server failed to start", map[string]any{"error": err.Error()})
 	}
 }
+
+// serveMetrics runs the metrics-only listener, meant to be reachable by the
+// monitoring network but not the internet.
+func serveMetrics() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", api.ServeMetrics)
+
+	logs.INFO("Starting metrics listener", map[string]any{"addr": config.MetricsAddr})
+	if err := http.ListenAndServe(config.MetricsAddr, mux); err != nil {
+		logs.ERROR("Metrics listener failed", map[string]any{"error": err.Error()})
+	}
+}
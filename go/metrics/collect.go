@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"io"
+	"runtime"
+	"server/db"
+	"time"
+)
+
+var startTime = time.Now()
+
+// Write renders every metric, reading gauges such as the database pool and the Go
+// runtime at scrape time.
+func Write(w io.Writer) {
+	for _, f := range []*family{httpRequests, httpDuration, jobRuns, jobFailures, jobDuration, jobLastSuccess} {
+		f.write(w)
+	}
+
+	gauges := newFamily("cnqso_scraper_posts", "Archived petrarchive posts, by whether they start a thread.", "gauge", nil, "kind")
+	if db.DB != nil {
+		var posts, threads int
+		if err := db.DB.QueryRow("SELECT COUNT(*), COALESCE(SUM(thread_owner = 1), 0) FROM posts").Scan(&posts, &threads); err == nil {
+			gauges.set(float64(threads), "thread")
+			gauges.set(float64(posts-threads), "reply")
+		}
+	}
+	gauges.write(w)
+
+	if db.DB != nil {
+		stats := db.DB.Stats()
+		for _, g := range []struct {
+			name, help string
+			value      float64
+		}{
+			{"cnqso_db_max_open_connections", "Maximum open SQLite connections.", float64(stats.MaxOpenConnections)},
+			{"cnqso_db_open_connections", "Open SQLite connections.", float64(stats.OpenConnections)},
+			{"cnqso_db_in_use_connections", "SQLite connections in use.", float64(stats.InUse)},
+			{"cnqso_db_idle_connections", "Idle SQLite connections.", float64(stats.Idle)},
+		} {
+			gauge := newFamily(g.name, g.help, "gauge", nil)
+			gauge.set(g.value)
+			gauge.write(w)
+		}
+		for _, c := range []struct {
+			name, help string
+			value      float64
+		}{
+			{"cnqso_db_wait_count_total", "Times a query waited for a SQLite connection.", float64(stats.WaitCount)},
+			{"cnqso_db_wait_duration_seconds_total", "Time spent waiting for SQLite connections.", stats.WaitDuration.Seconds()},
+			{"cnqso_db_max_idle_closed_total", "Connections closed for exceeding the idle limit.", float64(stats.MaxIdleClosed)},
+			{"cnqso_db_max_lifetime_closed_total", "Connections closed for exceeding their lifetime.", float64(stats.MaxLifetimeClosed)},
+		} {
+			counter := newFamily(c.name, c.help, "counter", nil)
+			counter.set(c.value)
+			counter.write(w)
+		}
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	for _, g := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"go_goroutines", "gauge", "Number of goroutines.", float64(runtime.NumGoroutine())},
+		{"go_memstats_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(mem.Alloc)},
+		{"go_memstats_heap_inuse_bytes", "gauge", "Bytes in in-use heap spans.", float64(mem.HeapInuse)},
+		{"go_memstats_sys_bytes", "gauge", "Bytes obtained from the OS.", float64(mem.Sys)},
+		{"go_memstats_heap_objects", "gauge", "Allocated heap objects.", float64(mem.HeapObjects)},
+		{"go_memstats_mallocs_total", "counter", "Heap objects allocated.", float64(mem.Mallocs)},
+		{"go_gc_cycles_total", "counter", "Completed GC cycles.", float64(mem.NumGC)},
+		{"go_gc_pause_seconds_total", "counter", "Total stop-the-world GC pause time.", float64(mem.PauseTotalNs) / 1e9},
+		{"process_start_time_seconds", "gauge", "Start time of the process since the Unix epoch.", float64(startTime.Unix())},
+	} {
+		metric := newFamily(g.name, g.help, g.kind, nil)
+		metric.set(g.value)
+		metric.write(w)
+	}
+
+	info := newFamily("go_info", "Go version the server was built with.", "gauge", nil, "version")
+	info.set(1, runtime.Version())
+	info.write(w)
+}
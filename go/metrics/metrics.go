@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A small implementation of the Prometheus text exposition format, enough for
+// counters, gauges and histograms with labels. See
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// Histogram buckets in seconds, from a fast static file up to a slow scrape
+var (
+	requestBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	jobBuckets     = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800}
+)
+
+type series struct {
+	labels  []string
+	value   float64
+	buckets []uint64 // Histograms only, cumulative counts are computed when written
+	count   uint64
+}
+
+type family struct {
+	sync.Mutex
+	name    string
+	help    string
+	kind    string // counter, gauge or histogram
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+func newFamily(name, help, kind string, buckets []float64, labels ...string) *family {
+	return &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+}
+
+func (f *family) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: values}
+		if f.kind == "histogram" {
+			s.buckets = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) add(delta float64, values ...string) {
+	f.Lock()
+	defer f.Unlock()
+	f.get(values).value += delta
+}
+
+func (f *family) set(value float64, values ...string) {
+	f.Lock()
+	defer f.Unlock()
+	f.get(values).value = value
+}
+
+func (f *family) observe(value float64, values ...string) {
+	f.Lock()
+	defer f.Unlock()
+	s := f.get(values)
+	s.value += value
+	s.count++
+	for i, bound := range f.buckets {
+		if value <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+}
+
+func (f *family) write(w io.Writer) {
+	f.Lock()
+	defer f.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labels, s.labels, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labels, s.labels, "", ""), s.count)
+	}
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	httpRequests = newFamily("cnqso_http_requests_total", "HTTP requests by route pattern, method and status.",
+		"counter", nil, "route", "method", "status")
+	httpDuration = newFamily("cnqso_http_request_duration_seconds", "HTTP request durations by route pattern and status.",
+		"histogram", requestBuckets, "route", "status")
+	jobRuns = newFamily("cnqso_job_runs_total", "Scheduled job runs.",
+		"counter", nil, "job")
+	jobFailures = newFamily("cnqso_job_failures_total", "Scheduled job runs that returned an error or panicked.",
+		"counter", nil, "job")
+	jobDuration = newFamily("cnqso_job_duration_seconds", "Scheduled job run durations.",
+		"histogram", jobBuckets, "job")
+	jobLastSuccess = newFamily("cnqso_job_last_success_timestamp_seconds", "Unix time of each job's last successful run.",
+		"gauge", nil, "job")
+)
+
+// ObserveRequest records a finished request. route is the ServeMux pattern that
+// matched, so bots probing random paths don't create new series.
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.add(1, route, method, strconv.Itoa(status))
+	httpDuration.observe(duration.Seconds(), route, strconv.Itoa(status))
+}
+
+// ObserveStream records a finished streaming response. It is counted like any
+// request, but stays out of the duration histogram since it lasts as long as the
+// client keeps it open.
+func ObserveStream(route, method string, status int) {
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.add(1, route, method, strconv.Itoa(status))
+}
+
+func ObserveJob(job string, duration time.Duration, failed bool) {
+	jobRuns.add(1, job)
+	jobDuration.observe(duration.Seconds(), job)
+	if failed {
+		jobFailures.add(1, job)
+		return
+	}
+	jobFailures.add(0, job) // So the series exists before the first failure
+	jobLastSuccess.set(float64(time.Now().Unix()), job)
+}
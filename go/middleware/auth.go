@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"server/config"
+	"server/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AdminCookie     = "cnqso_admin"
+	AdminSessionTTL = 30 * 24 * time.Hour
+)
+
+// IsAdmin accepts the admin token as a bearer token or the session cookie set by /admin/login.
+func IsAdmin(r *http.Request) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+	}
+
+	cookie, err := r.Cookie(AdminCookie)
+	return err == nil && validAdminSession(cookie.Value, time.Now())
+}
+
+// AdminSession returns a cookie value that is signed with the admin token rather
+// than containing it, so a leaked cookie expires and never reveals the token.
+// Changing the token signs out every session.
+func AdminSession(now time.Time) string {
+	expires := strconv.FormatInt(now.Add(AdminSessionTTL).Unix(), 10)
+	return expires + "." + signAdminSession(expires)
+}
+
+func validAdminSession(session string, now time.Time) bool {
+	expires, signature, found := strings.Cut(session, ".")
+	if !found {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(signAdminSession(expires)))
+}
+
+func signAdminSession(expires string) string {
+	mac := hmac.New(sha256.New, []byte(config.AdminToken))
+	mac.Write([]byte("admin-session|" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// RequireAdmin rejects API requests with a 401 and sends page requests to the login form.
+func RequireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if IsAdmin(r) {
+			handler(w, r)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(types.JSONResponse{
+				Success: false,
+				Message: "Unauthorized",
+			})
+			return
+		}
+
+		http.Redirect(w, r, "/admin/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,208 @@
+package search
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"server/db"
+	"strings"
+	"unicode"
+)
+
+// Enabled is false when the SQLite build lacks FTS5 (build with -tags sqlite_fts5).
+var Enabled bool
+
+type Result struct {
+	Kind    string        `json:"kind"`
+	Title   string        `json:"title"`
+	Author  string        `json:"author,omitempty"`
+	URL     string        `json:"url"`
+	Snippet template.HTML `json:"snippet"`
+}
+
+// FTS5 markers that are swapped for <mark> tags once the snippet has been escaped
+const (
+	markOpen  = "\x02"
+	markClose = "\x03"
+)
+
+func Init() error {
+	_, err := db.DB.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+			kind UNINDEXED,
+			ref UNINDEXED,
+			url UNINDEXED,
+			version UNINDEXED,
+			title,
+			author,
+			body,
+			tokenize = 'porter unicode61'
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	// Posts stored while the index was unavailable are picked up here, whether the
+	// index is new or already holds the rest. Refs are compared as text, since the
+	// backfill stores ids as numbers and IndexArchivePost as strings.
+	_, err = db.DB.Exec(`
+		INSERT INTO search_index (kind, ref, url, version, title, author, body)
+		SELECT 'post', id, '/petrarchive/thread/' || thread || '#post-' || id, '', title, poster, contents
+		FROM posts
+		WHERE CAST(id AS TEXT) NOT IN (SELECT CAST(ref AS TEXT) FROM search_index WHERE kind = 'post')
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill search index: %w", err)
+	}
+
+	Enabled = true
+	return nil
+}
+
+func IndexArchivePost(id, threadID, title, poster, contents string) error {
+	if !Enabled {
+		return nil
+	}
+
+	url := "/petrarchive/thread/" + threadID + "#post-" + id
+	return replace("post", id, url, "", title, poster, contents)
+}
+
+func IndexBlogPost(slug, title, text, version string) error {
+	if !Enabled {
+		return nil
+	}
+
+	return replace("blog", slug, "/blog/"+slug, version, title, "", text)
+}
+
+func RemoveBlogPost(slug string) error {
+	if !Enabled {
+		return nil
+	}
+
+	_, err := db.DB.Exec("DELETE FROM search_index WHERE kind = 'blog' AND ref = ?", slug)
+	return err
+}
+
+// BlogVersions maps each indexed blog slug to the version it was indexed at.
+func BlogVersions() (map[string]string, error) {
+	versions := make(map[string]string)
+	if !Enabled {
+		return versions, nil
+	}
+
+	rows, err := db.DB.Query("SELECT ref, version FROM search_index WHERE kind = 'blog'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slug, version string
+		if err := rows.Scan(&slug, &version); err != nil {
+			return nil, err
+		}
+		versions[slug] = version
+	}
+
+	return versions, nil
+}
+
+func replace(kind, ref, url, version, title, author, body string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM search_index WHERE kind = ? AND ref = ?", kind, ref)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO search_index (kind, ref, url, version, title, author, body)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		kind, ref, url, version, title, author, body,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func Query(query string, limit int) ([]Result, error) {
+	if !Enabled {
+		return nil, fmt.Errorf("search is not available")
+	}
+
+	match := matchExpression(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT kind, title, author, url,
+			snippet(search_index, 6, ?, ?, '…', 24)
+		FROM search_index
+		WHERE search_index MATCH ?
+		ORDER BY bm25(search_index, 0, 0, 0, 0, 10.0, 2.0, 1.0)
+		LIMIT ?`,
+		markOpen, markClose, match, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var result Result
+		var snippet string
+		if err := rows.Scan(&result.Kind, &result.Title, &result.Author, &result.URL, &snippet); err != nil {
+			return nil, err
+		}
+		result.Snippet = highlight(snippet)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// matchExpression quotes every word so user input can't trip FTS5 query syntax,
+// and prefix-matches the last word so partially typed queries still hit.
+func matchExpression(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"`
+	}
+	terms[len(terms)-1] += "*"
+
+	return strings.Join(terms, " ")
+}
+
+func highlight(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, markOpen, "<mark>")
+	escaped = strings.ReplaceAll(escaped, markClose, "</mark>")
+	return template.HTML(escaped)
+}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// PlainText strips rendered HTML down to the words a reader would see.
+func PlainText(rendered string) string {
+	text := html.UnescapeString(tagPattern.ReplaceAllString(rendered, " "))
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,7 @@
+// escapeHTML makes a value safe to put in element content and in double-quoted
+// attributes of the admin pages' template literals.
+function escapeHTML(value) {
+    const div = document.createElement("div");
+    div.textContent = value ?? "";
+    return div.innerHTML.replaceAll('"', "&quot;");
+}
@@ -17,13 +17,76 @@ type Route struct {
 }
 
 type BlogPost struct {
-	Slug     string
+	Slug        string
+	Title       string
+	Date        time.Time
+	Content     template.HTML
+	FilePath    string
+	Draft       bool
+	TOC         []TOCEntry
+	WordCount   int
+	ReadingTime int    // Minutes
+	Description string // First paragraph, as plain text
+	Image       string // First image in the post, if any
+
+	Math          bool // Needs the KaTeX stylesheet
+	ClientMath    bool // Some math is left for KaTeX to render in the browser
+	ClientMermaid bool // Some diagrams are left for mermaid to render in the browser
+}
+
+type TOCEntry struct {
+	ID       string
 	Title    string
-	Date     time.Time
-	Content  template.HTML
-	FilePath string
+	Level    int
+	Children []TOCEntry
 }
 
 type BlogData struct {
 	Posts []BlogPost
 }
+
+type BlogPostPage struct {
+	BlogPost
+	Meta        PageMeta
+	Webmentions []Webmention
+	Comments    []Comment
+	CommentSent bool
+}
+
+type Webmention struct {
+	ID         int       `json:"id"`
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	Slug       string    `json:"slug"`
+	Status     string    `json:"status"`     // queued, verified or invalid
+	Moderation string    `json:"moderation"` // pending, approved or rejected
+	AuthorName string    `json:"author_name"`
+	AuthorURL  string    `json:"author_url"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Error      string    `json:"error,omitempty"`
+	Received   time.Time `json:"received"`
+}
+
+type Comment struct {
+	ID       int           `json:"id"`
+	Slug     string        `json:"slug"`
+	ParentID int           `json:"parent_id,omitempty"`
+	Name     string        `json:"name"`
+	Body     string        `json:"body"`
+	Status   string        `json:"status"` // pending, approved or rejected
+	Created  time.Time     `json:"created"`
+	HTML     template.HTML `json:"-"`
+	Replies  []Comment     `json:"-"`
+}
+
+// PageMeta drives the description, canonical, Open Graph, Twitter card and JSON-LD tags of a page.
+type PageMeta struct {
+	Title       string
+	Description string
+	URL         string
+	Image       string
+	Type        string // og:type
+	Published   time.Time
+	JSONLD      any
+}